@@ -2,13 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/komodorio/komocli/pkg/cp"
+	"github.com/komodorio/komocli/pkg/doctor"
+	"github.com/komodorio/komocli/pkg/exec"
+	"github.com/komodorio/komocli/pkg/logging"
+	"github.com/komodorio/komocli/pkg/ping"
 	"github.com/komodorio/komocli/pkg/portforward"
+	"github.com/komodorio/komocli/pkg/recordings"
+	"github.com/komodorio/komocli/pkg/replay"
+	"github.com/komodorio/komocli/pkg/tracing"
+	"github.com/komodorio/komocli/pkg/ui"
 	"github.com/spf13/cobra"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
@@ -21,8 +32,15 @@ var (
 )
 
 const flagVerbose = "verbose"
+const flagLogPayloads = "log-payloads"
+const flagLogFormat = "log-format"
+const flagLogLevel = "log-level"
+const flagLogFile = "log-file"
+const flagLogMaxSize = "log-max-size"
+const flagLogMaxBackups = "log-max-backups"
 
 var rootCtxCancel context.CancelFunc = func() {}
+var shutdownTracing = func(context.Context) error { return nil }
 var RootCmd = &cobra.Command{
 	Use:           filepath.Base(os.Args[0]),
 	Version:       version,
@@ -36,24 +54,46 @@ var RootCmd = &cobra.Command{
 			fmt.Println("Failed to remember app version because of error: " + err.Error())
 		}
 
-		if verbose, err := cmd.Flags().GetBool(flagVerbose); err == nil {
-			verbose = verbose || os.Getenv("DEBUG") != ""
-			setupLogging(verbose)
-		} else {
+		logging.LogPayloads, err = cmd.Flags().GetBool(flagLogPayloads)
+		if err != nil {
+			return err
+		}
+
+		err = setupLogging(cmd)
+		if err != nil {
 			return err
 		}
 
-		ctx, cancel := context.WithCancel(context.Background())
+		// quitting the dashboard counts as the first signal, the next one forces the stop
+		osSignal := make(chan os.Signal, 2)
+		stopRequest := func() {
+			select {
+			case osSignal <- os.Interrupt:
+			default:
+			}
+		}
+
+		force := make(chan struct{})
+		ctx := portforward.WithStopRequest(portforward.WithForceStop(context.Background(), force), stopRequest)
+		ctx, cancel := context.WithCancel(ctx)
 		rootCtxCancel = cancel
 
+		shutdownTracing, err = tracing.Setup(ctx, version)
+		if err != nil {
+			return fmt.Errorf("failed to set up tracing: %w", err)
+		}
+
 		cmd.SetContext(ctx)
 
-		osSignal := make(chan os.Signal, 1)
 		signal.Notify(osSignal, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 		go func() {
 			oscall := <-osSignal
 			log.Warnf("Stopping on signal: %s\n", oscall)
 			rootCtxCancel()
+
+			oscall = <-osSignal // while draining connections
+			log.Warnf("Forced to stop on signal: %s", oscall)
+			close(force)
 		}()
 
 		return nil
@@ -65,26 +105,88 @@ var RootCmd = &cobra.Command{
 
 func init() {
 	RootCmd.PersistentFlags().BoolP("verbose", "v", false, "Show verbose debug information and logging")
+	RootCmd.PersistentFlags().Bool(flagLogPayloads, false, "Log session data and exec commands in verbose mode instead of their size, they may contain sensitive data")
+	RootCmd.PersistentFlags().String(flagLogFormat, logging.FormatText, "Log format: text or json")
+	RootCmd.PersistentFlags().String(flagLogLevel, "info", "Log level: trace, debug, info, warn or error. --verbose sets it to debug")
+	RootCmd.PersistentFlags().String(flagLogFile, "", "Write logs into this file instead of stderr, rotating it by size")
+	RootCmd.PersistentFlags().Int(flagLogMaxSize, 100, "Size in megabytes at which --log-file gets rotated")
+	RootCmd.PersistentFlags().Int(flagLogMaxBackups, 3, "Number of rotated log files to keep")
 
 	RootCmd.AddCommand(portforward.NewCommand())
+	RootCmd.AddCommand(exec.NewCommand())
+	RootCmd.AddCommand(cp.NewCommand())
+	RootCmd.AddCommand(recordings.NewCommand())
+	RootCmd.AddCommand(doctor.NewCommand())
+	RootCmd.AddCommand(ping.NewCommand())
+	RootCmd.AddCommand(replay.NewCommand())
+	RootCmd.AddCommand(ui.NewCommand())
 }
 
 func main() {
-	if err := RootCmd.Execute(); err != nil {
+	logging.InstallRedaction() // before anything gets logged
+	err := RootCmd.Execute()
+	flushTraces() // failed commands are worth tracing the most
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		log.Debugf("%s", exitErr)
+		os.Exit(exitErr.Code)
+	}
+
+	if err != nil {
 		log.Fatalf("Failed running CLI: %s", err)
 	}
 
 	log.Infof("Done.")
 }
 
-func setupLogging(verbose bool) {
-	if verbose {
-		log.SetLevel(log.DebugLevel)
+func flushTraces() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := shutdownTracing(ctx)
+	if err != nil {
+		log.Warnf("Failed to export traces: %s", err)
+	}
+}
+
+func setupLogging(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	verbose, err := flags.GetBool(flagVerbose)
+	if err != nil {
+		return err
+	}
+
+	opts := logging.Options{}
+	for flag, dst := range map[string]*string{flagLogFormat: &opts.Format, flagLogLevel: &opts.Level, flagLogFile: &opts.File} {
+		*dst, err = flags.GetString(flag)
+		if err != nil {
+			return err
+		}
+	}
+
+	for flag, dst := range map[string]*int{flagLogMaxSize: &opts.MaxSizeMB, flagLogMaxBackups: &opts.MaxBackups} {
+		*dst, err = flags.GetInt(flag)
+		if err != nil {
+			return err
+		}
+	}
+
+	if (verbose || os.Getenv("DEBUG") != "") && !flags.Changed(flagLogLevel) {
+		opts.Level = log.DebugLevel.String()
+	}
+
+	err = logging.Configure(opts)
+	if err != nil {
+		return err
+	}
+
+	if log.IsLevelEnabled(log.DebugLevel) {
 		gin.SetMode(gin.DebugMode)
 		log.Debugf("Debug logging is enabled")
 	} else {
-		log.SetLevel(log.InfoLevel)
 		gin.SetMode(gin.ReleaseMode)
 	}
-	log.Infof("Komodor CLI, version %s (%s @ %s)", version, commit, date)
+	log.WithFields(log.Fields{"version": version, "commit": commit, "date": date}).Debugf("Komodor CLI, version %s", version)
+	return nil
 }
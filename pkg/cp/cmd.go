@@ -0,0 +1,286 @@
+package cp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/exec"
+	"github.com/komodorio/komocli/pkg/portforward"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+const flagToken = "token"
+const flagCluster = "cluster"
+const flagNamespace = "namespace"
+const flagContainer = "container"
+const flagTimeout = "timeout"
+
+var (
+	cpLong = templates.LongDesc(`
+		Copy files and directories to and from containers.
+
+		Files are streamed as tar archive through exec session, so the container needs to have tar installed.
+		Directories are copied recursively, file modes are preserved.
+
+		Archive entries pointing outside of the local destination are refused.`)
+
+	cpExample = templates.Examples(`
+		# Copy a file from the pod into current directory
+		komocli cp pod/mypod:/var/log/app.log ./ --namespace default --cluster my-cluster --token=...
+
+		# Copy local directory into /tmp/config in the container
+		komocli cp ./config pod/mypod:/tmp/config -c app --namespace default --cluster my-cluster --token=...`)
+)
+
+// location is either local path, or path in the pod
+type location struct {
+	Pod  string
+	Path string
+}
+
+func (l location) isRemote() bool {
+	return l.Pod != ""
+}
+
+// parseLocation tells pod/name:/path from local paths, like ./a:b or C:\path on Windows
+func parseLocation(arg string) (location, error) {
+	prefix, p, found := strings.Cut(arg, ":")
+	if !found || len(prefix) < 2 || strings.ContainsAny(prefix, `\`) || strings.HasPrefix(prefix, ".") || strings.HasPrefix(prefix, "/") {
+		return location{Path: arg}, nil
+	}
+
+	pod, err := exec.PodName(prefix)
+	if err != nil {
+		return location{}, err
+	}
+
+	if p == "" {
+		return location{}, fmt.Errorf("path in the pod is required: %s", arg)
+	}
+	return location{Pod: pod, Path: p}, nil
+}
+
+type CmdParams struct {
+	Src       location
+	Dst       location
+	Token     string
+	Cluster   string
+	Namespace string
+	Container string
+	Timeout   time.Duration
+	Dial      *portforward.DialOptions
+}
+
+func (p *CmdParams) AcceptArgs(cmd *cobra.Command, args []string) (err error) {
+	p.Src, err = parseLocation(args[0])
+	if err != nil {
+		return err
+	}
+
+	p.Dst, err = parseLocation(args[1])
+	if err != nil {
+		return err
+	}
+
+	if p.Src.isRemote() == p.Dst.isRemote() {
+		return errors.New("exactly one of source and destination has to be in a pod, like pod/mypod:/path")
+	}
+
+	flags := cmd.Flags()
+	p.Token, err = flags.GetString(flagToken)
+	if err != nil {
+		return err
+	}
+
+	if p.Token == "" {
+		p.Token = os.Getenv("KOMOCLI_JWT")
+	}
+
+	for flag, dst := range map[string]*string{flagCluster: &p.Cluster, flagNamespace: &p.Namespace, flagContainer: &p.Container} {
+		*dst, err = flags.GetString(flag)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.Timeout, err = flags.GetDuration(flagTimeout)
+	if err != nil {
+		return err
+	}
+
+	p.Dial, err = portforward.AcceptDialFlags(cmd)
+	return err
+}
+
+// remotePath splits the path in the pod into directory for tar -C and the name within it
+func remotePath(p string) (dir string, base string, err error) {
+	p = path.Clean(p)
+	dir, base = path.Dir(p), path.Base(p)
+	if base == "/" || base == "." || base == ".." {
+		return "", "", fmt.Errorf("can't copy %s, name a file or directory", p)
+	}
+
+	if strings.ContainsAny(p, " \t\n") {
+		return "", "", fmt.Errorf("paths with whitespace in the pod are not supported: %q", p)
+	}
+	return dir, base, nil
+}
+
+func (p *CmdParams) newSession(ctx context.Context, pod string, command ...string) *exec.Session {
+	target := exec.Target{Cluster: p.Cluster, Namespace: p.Namespace, Pod: pod, Container: p.Container, Command: command}
+	return exec.NewSession(ctx, target, p.Token, portforward.NewTimeouts(p.Timeout, portforward.Timeouts{}), p.Dial)
+}
+
+func (p *CmdParams) Run(ctx context.Context) error {
+	if !log.IsLevelEnabled(log.DebugLevel) {
+		log.SetLevel(log.WarnLevel) // info lines would get in the way of progress
+	}
+
+	if p.Src.isRemote() {
+		return p.download(ctx)
+	}
+	return p.upload(ctx)
+}
+
+// download runs tar in the pod and extracts its output locally
+func (p *CmdParams) download(ctx context.Context) error {
+	dir, base, err := remotePath(p.Src.Path)
+	if err != nil {
+		return err
+	}
+
+	// into existing directory, or as the given name
+	root, target := filepath.Dir(p.Dst.Path), filepath.Base(p.Dst.Path)
+	if info, err := os.Stat(p.Dst.Path); err == nil && info.IsDir() {
+		root, target = p.Dst.Path, base
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	archive, output := io.Pipe()
+	extracted := make(chan error, 1)
+	go func() {
+		err := extractTar(archive, root, base, filepath.ToSlash(target))
+		if err != nil {
+			cancel(err)
+			_ = archive.CloseWithError(err)
+		}
+		_, _ = io.Copy(io.Discard, archive) // padding after end of archive
+		extracted <- err
+	}()
+
+	prog := newProgress("Received")
+	sess := p.newSession(ctx, p.Src.Pod, "tar", "cf", "-", "-C", dir, base)
+	err = sess.Run(nil, io.MultiWriter(output, prog))
+	_ = output.Close()
+	extractErr := <-extracted
+	prog.finish()
+
+	return copyResult(sess, err, extractErr, nil)
+}
+
+// upload streams local files as tar archive into tar running in the pod
+func (p *CmdParams) upload(ctx context.Context) error {
+	_, err := os.Stat(p.Src.Path)
+	if err != nil {
+		return err
+	}
+
+	dst := p.Dst.Path
+	if strings.HasSuffix(dst, "/") {
+		dst += filepath.Base(p.Src.Path)
+	}
+
+	dir, base, err := remotePath(dst)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	prog := newProgress("Sent")
+	archive, input := io.Pipe()
+	go func() {
+		err := writeTar(io.MultiWriter(input, prog), p.Src.Path, base)
+		if err != nil {
+			cancel(fmt.Errorf("failed to archive %s: %w", p.Src.Path, err))
+		}
+		_ = input.CloseWithError(err)
+	}()
+
+	output := bytes.Buffer{}
+	sess := p.newSession(ctx, p.Dst.Pod, "tar", "xf", "-", "-C", dir)
+	err = sess.Run(archive, &output)
+	_ = archive.Close()
+	prog.finish()
+
+	return copyResult(sess, err, nil, &output)
+}
+
+// copyResult picks the most meaningful error of the copy
+func copyResult(sess *exec.Session, sessErr error, extractErr error, output *bytes.Buffer) error {
+	if sessErr != nil && !errors.Is(sessErr, extractErr) {
+		return fmt.Errorf("error while copying: %w", sessErr)
+	}
+
+	if code := sess.ExitCode(); code != 0 {
+		msg := fmt.Sprintf("tar in the container exited with code %d, check that tar is installed and the path exists", code)
+		if output != nil && output.Len() > 0 {
+			msg += ": " + strings.TrimSpace(output.String())
+		}
+		return errors.New(msg)
+	}
+
+	if extractErr != nil {
+		return fmt.Errorf("failed to extract received files: %w", extractErr)
+	}
+	return nil
+}
+
+func NewCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:     "cp SRC DST",
+		Short:   "Copy files and directories to and from containers",
+		Long:    cpLong,
+		Example: cpExample,
+		Args:    cobra.ExactArgs(2),
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := CmdParams{}
+			err := opts.AcceptArgs(c, args)
+			if err != nil {
+				return err
+			}
+
+			return opts.Run(c.Context())
+		},
+	}
+
+	setupFlags(cmd)
+	err := cmd.MarkFlagRequired(flagCluster)
+	if err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+func setupFlags(cmd *cobra.Command) {
+	cmd.Flags().String(flagToken, "", "JWT Authentication token")
+	cmd.Flags().String(flagCluster, "", "Komodor cluster name that contains the pod")
+	cmd.Flags().String(flagNamespace, "default", "Namespace of the pod")
+	cmd.Flags().StringP(flagContainer, "c", "", "Container name, defaults to the first container of the pod")
+	cmd.Flags().Duration(flagTimeout, 5*time.Second, "Timeout for connecting and acknowledgements of the session")
+	portforward.SetupDialFlags(cmd)
+}
@@ -0,0 +1,42 @@
+package cp
+
+import (
+	"testing"
+)
+
+func TestParseLocation(t *testing.T) {
+	cases := map[string]location{
+		"pod/mypod:/var/log": {Pod: "mypod", Path: "/var/log"},
+		"mypod:/tmp/a.txt":   {Pod: "mypod", Path: "/tmp/a.txt"},
+		"./dir:with:colons":  {Path: "./dir:with:colons"},
+		`C:\Users\me`:        {Path: `C:\Users\me`},
+		"/abs/path":          {Path: "/abs/path"},
+		"file.txt":           {Path: "file.txt"},
+	}
+
+	for arg, expected := range cases {
+		loc, err := parseLocation(arg)
+		if err != nil || loc != expected {
+			t.Errorf("%s: expected %+v, got %+v (%v)", arg, expected, loc, err)
+		}
+	}
+
+	for _, arg := range []string{"pod/mypod:", "deployment/web:/tmp"} {
+		if _, err := parseLocation(arg); err == nil {
+			t.Errorf("%s is not supposed to be accepted", arg)
+		}
+	}
+}
+
+func TestRemotePath(t *testing.T) {
+	dir, base, err := remotePath("/var/log/app/")
+	if err != nil || dir != "/var/log" || base != "app" {
+		t.Errorf("unexpected split: %s %s (%v)", dir, base, err)
+	}
+
+	for _, p := range []string{"/", ".", "/tmp/..", "/tmp/my file"} {
+		if _, _, err := remotePath(p); err == nil {
+			t.Errorf("%q is not supposed to be accepted", p)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package cp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/portforward"
+	"golang.org/x/term"
+)
+
+const progressInterval = 200 * time.Millisecond
+
+// progress counts bytes written through it and keeps the count updated on stderr, if it is a terminal
+type progress struct {
+	verb string
+	out  io.Writer
+	n    atomic.Int64
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newProgress(verb string) *progress {
+	p := &progress{verb: verb, out: os.Stderr, stop: make(chan struct{}), done: make(chan struct{})}
+	if !term.IsTerminal(int(os.Stderr.Fd())) {
+		close(p.done)
+		return p
+	}
+
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				_, _ = fmt.Fprintf(p.out, "\r%s %s ", p.verb, portforward.FormatBytes(p.n.Load()))
+			}
+		}
+	}()
+	return p
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.n.Add(int64(len(b)))
+	return len(b), nil
+}
+
+// finish prints the final count
+func (p *progress) finish() {
+	close(p.stop)
+	<-p.done
+	_, _ = fmt.Fprintf(p.out, "\r%s %s\n", p.verb, portforward.FormatBytes(p.n.Load()))
+}
@@ -0,0 +1,227 @@
+package cp
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tarRecordSize is what tar reads from a pipe at once. Komodor sessions can't signal end of input,
+// so the archive is padded to full records for remote tar to finish on the end-of-archive marker
+const tarRecordSize = 20 * 512
+
+// writeTar archives the local file or directory, naming it as base in the archive
+func writeTar(w io.Writer, src string, base string) error {
+	counter := &countingWriter{w: w}
+	tw := tar.NewWriter(counter)
+
+	err := filepath.WalkDir(src, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		return addToTar(tw, file, path.Join(base, filepath.ToSlash(rel)))
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	if rest := counter.n % tarRecordSize; rest != 0 {
+		_, err = w.Write(make([]byte, tarRecordSize-rest))
+	}
+	return err
+}
+
+func addToTar(tw *tar.Writer, file string, name string) error {
+	info, err := os.Lstat(file)
+	if err != nil {
+		return err
+	}
+
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err = os.Readlink(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		log.Warnf("Skipping %s: %s", file, err) // sockets and such
+		return nil
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+
+	err = tw.WriteHeader(hdr)
+	if err != nil || !info.Mode().IsRegular() {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// extractTar unpacks the archive into dir, entries are expected to be under base, which gets renamed into target
+func extractTar(r io.Reader, dir string, base string, target string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name, err := entryName(hdr, base, target)
+		if err != nil {
+			return err
+		}
+
+		dst := filepath.Join(dir, name)
+		err = refuseSymlinks(hdr, dir, dst)
+		if err != nil {
+			return err
+		}
+
+		err = extractEntry(tr, hdr, dst)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// refuseSymlinks refuses the entry when the path to it under dir goes through a symlink, as links extracted
+// earlier can lead outside of dir together, even if each of them points inside when checked on its own
+func refuseSymlinks(hdr *tar.Header, dir string, dst string) error {
+	last := filepath.Dir(dst)
+	if hdr.Typeflag == tar.TypeDir {
+		last = dst // it gets chmod, which follows symlinks
+	}
+
+	rel, err := filepath.Rel(dir, last)
+	if err != nil || rel == "." {
+		return err
+	}
+
+	cur := dir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, part)
+		info, err := os.Lstat(cur)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // the rest gets created as directories
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("refusing archive entry %q that goes through symlink %s", hdr.Name, cur)
+		}
+	}
+	return nil
+}
+
+// entryName maps the archive entry under base to local name under target, refusing the ones escaping it
+func entryName(hdr *tar.Header, base string, target string) (string, error) {
+	name := strings.TrimSuffix(hdr.Name, "/")
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("refusing archive entry %q that points outside of destination", hdr.Name)
+	}
+
+	rel, found := strings.CutPrefix(name, base)
+	if !found || rel != "" && !strings.HasPrefix(rel, "/") {
+		return "", fmt.Errorf("unexpected archive entry %q, expected only %s", hdr.Name, base)
+	}
+
+	if hdr.Typeflag == tar.TypeSymlink {
+		resolved := path.Join(path.Dir(name), hdr.Linkname)
+		if path.IsAbs(hdr.Linkname) || resolved != base && !strings.HasPrefix(resolved, base+"/") {
+			return "", fmt.Errorf("refusing link %q to %q that points outside of destination", hdr.Name, hdr.Linkname)
+		}
+	}
+	return filepath.FromSlash(target + rel), nil
+}
+
+func extractEntry(tr *tar.Reader, hdr *tar.Header, dst string) error {
+	mode := hdr.FileInfo().Mode().Perm()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		err := os.MkdirAll(dst, mode|0o700) // we need to write into it ourselves
+		if err != nil {
+			return err
+		}
+		return os.Chmod(dst, mode|0o700)
+	case tar.TypeReg:
+		return extractFile(tr, dst, mode)
+	case tar.TypeSymlink:
+		_ = os.Remove(dst)
+		return os.Symlink(hdr.Linkname, dst)
+	default:
+		log.Warnf("Skipping %s: unsupported type of archive entry %c", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+}
+
+func extractFile(r io.Reader, dst string, mode fs.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(dst), 0o755)
+	if err != nil {
+		return err
+	}
+
+	_ = os.Remove(dst) // don't write through existing symlink
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Chmod(dst, mode) // umask does not apply to copied files
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,105 @@
+package cp
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestTarRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	must(t, os.MkdirAll(filepath.Join(src, "sub"), 0o755))
+	must(t, os.WriteFile(filepath.Join(src, "run.sh"), []byte("#!/bin/sh\n"), 0o750))
+	must(t, os.WriteFile(filepath.Join(src, "sub", "data.txt"), []byte("data"), 0o640))
+	if runtime.GOOS != "windows" {
+		must(t, os.Symlink("sub/data.txt", filepath.Join(src, "link")))
+	}
+
+	archive := bytes.Buffer{}
+	must(t, writeTar(&archive, src, "app"))
+	if archive.Len()%tarRecordSize != 0 {
+		t.Errorf("archive is not padded to full records: %d", archive.Len())
+	}
+
+	dst := t.TempDir()
+	must(t, extractTar(&archive, dst, "app", "copy"))
+
+	data, err := os.ReadFile(filepath.Join(dst, "copy", "sub", "data.txt"))
+	if err != nil || string(data) != "data" {
+		t.Errorf("unexpected content: %q (%v)", data, err)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "copy", "run.sh"))
+	if err != nil || info.Mode().Perm() != 0o750 {
+		t.Errorf("mode is not preserved: %v (%v)", info.Mode(), err)
+	}
+
+	if link, err := os.Readlink(filepath.Join(dst, "copy", "link")); err != nil || link != "sub/data.txt" {
+		t.Errorf("unexpected symlink: %q (%v)", link, err)
+	}
+}
+
+func TestExtractRefusesTraversal(t *testing.T) {
+	cases := [][]tar.Header{
+		{{Name: "app/../../evil", Typeflag: tar.TypeReg}},
+		{{Name: "/etc/evil", Typeflag: tar.TypeReg}},
+		{{Name: "other/file", Typeflag: tar.TypeReg}},
+		{{Name: "app/link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}},
+		{{Name: "app/link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+		{ // each link points inside on its own, but together they lead out
+			{Name: "app/", Typeflag: tar.TypeDir, Mode: 0o755},
+			{Name: "app/d", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "app/d/d/d/l", Typeflag: tar.TypeSymlink, Linkname: "../../.."},
+			{Name: "app/l/evil", Typeflag: tar.TypeReg},
+		},
+		{ // directories get chmod, which follows symlinks
+			{Name: "app/", Typeflag: tar.TypeDir, Mode: 0o755},
+			{Name: "app/d", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "app/d/", Typeflag: tar.TypeDir, Mode: 0o777},
+		},
+	}
+
+	for _, entries := range cases {
+		if runtime.GOOS == "windows" && len(entries) > 1 {
+			continue // symlinks need privileges there
+		}
+
+		archive := bytes.Buffer{}
+		tw := tar.NewWriter(&archive)
+		for _, hdr := range entries {
+			must(t, tw.WriteHeader(&hdr))
+		}
+		must(t, tw.Close())
+
+		root := t.TempDir()
+		dst := filepath.Join(root, "a", "b")
+		must(t, os.MkdirAll(dst, 0o755))
+
+		last := entries[len(entries)-1]
+		if err := extractTar(&archive, dst, "app", "app"); err == nil {
+			t.Errorf("expected %s -> %s to be refused", last.Name, last.Linkname)
+		}
+
+		if extracted, _ := os.ReadDir(root); len(extracted) != 1 {
+			t.Errorf("nothing is supposed to be extracted outside of destination for %s", last.Name)
+		}
+
+		if extracted, _ := os.ReadDir(dst); len(extracted) > 0 && len(entries) == 1 {
+			t.Errorf("nothing is supposed to be extracted for %s", last.Name)
+		}
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
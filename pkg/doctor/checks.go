@@ -0,0 +1,418 @@
+package doctor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/komodorio/komocli/pkg/logging"
+	"github.com/komodorio/komocli/pkg/portforward"
+)
+
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn" // problem that does not prevent further checks
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip"
+)
+
+type CheckResult struct {
+	Name       string `json:"name"`
+	Status     Status `json:"status"`
+	Details    string `json:"details,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Hint       string `json:"hint,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+type Report struct {
+	Version   string        `json:"version"`
+	Timestamp time.Time     `json:"timestamp"`
+	WSURL     string        `json:"wsUrl"`
+	Proxy     string        `json:"proxy,omitempty"`
+	Cluster   string        `json:"cluster"`
+	Target    string        `json:"target,omitempty"`
+	Checks    []CheckResult `json:"checks"`
+}
+
+func (r *Report) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// hintedError carries remediation advice along with the error
+type hintedError struct {
+	error
+	hint string
+}
+
+func withHint(err error, hint string) error {
+	return &hintedError{error: err, hint: hint}
+}
+
+// warning marks the problem as non-fatal for the following checks
+type warning struct {
+	hintedError
+}
+
+type check struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// Doctor checks connectivity to Komodor one layer at a time
+type Doctor struct {
+	params    *CmdParams
+	base      *url.URL
+	proxy     *url.URL
+	transport portforward.Transport
+}
+
+func (d *Doctor) Run(ctx context.Context) *Report {
+	report := &Report{
+		Version:   d.params.Version,
+		Timestamp: time.Now(),
+		WSURL:     portforward.WSBaseURL(),
+		Cluster:   d.params.Cluster,
+		Target:    d.params.target(),
+	}
+
+	defer func() {
+		if d.transport != nil {
+			_ = d.transport.Close()
+		}
+	}()
+
+	checks := []check{
+		{"Endpoint URL", d.checkURL},
+		{"DNS resolution", d.checkDNS},
+		{"TCP connection", d.checkTCP},
+		{"TLS handshake", d.checkTLS},
+		{"Connection upgrade and authentication", d.checkWS},
+		{"Agent presence (best-effort)", d.checkAgent},
+		{"Trial port-forward session", d.checkPortForward},
+	}
+
+	failed := false
+	for _, c := range checks {
+		res := CheckResult{Name: c.name, Status: StatusSkip}
+		if failed {
+			res.Details = "skipped due to previous failure"
+			report.Checks = append(report.Checks, res)
+			continue
+		}
+
+		started := time.Now()
+		details, err := c.run(ctx)
+		res.DurationMs = time.Since(started).Milliseconds()
+		res.Details = details
+		res.Status = statusOf(details, err)
+		if err != nil {
+			res.Error = logging.Redact(err.Error()) // errors may contain URLs with the token
+			var hinted *hintedError
+			var warned *warning
+			if errors.As(err, &warned) {
+				res.Hint = warned.hint
+			} else if errors.As(err, &hinted) {
+				res.Hint = hinted.hint
+			}
+		}
+		failed = res.Status == StatusFail
+
+		report.Checks = append(report.Checks, res)
+	}
+
+	if d.proxy != nil {
+		report.Proxy = d.proxy.Redacted()
+	}
+
+	return report
+}
+
+func statusOf(details string, err error) Status {
+	var warned *warning
+	switch {
+	case err == nil && details == "":
+		return StatusSkip
+	case err == nil:
+		return StatusPass
+	case errors.As(err, &warned):
+		return StatusWarn
+	default:
+		return StatusFail
+	}
+}
+
+func (d *Doctor) checkURL(_ context.Context) (string, error) {
+	var err error
+	d.base, err = url.Parse(portforward.WSBaseURL())
+	if err != nil {
+		return "", withHint(err, "Fix the value of KOMOCLI_WS_URL environment variable")
+	}
+
+	if d.base.Scheme != "ws" && d.base.Scheme != "wss" {
+		return "", withHint(fmt.Errorf("unsupported scheme %q", d.base.Scheme), "KOMOCLI_WS_URL has to start with wss:// or ws://")
+	}
+
+	d.proxy, err = d.params.Dial.ProxyFor(d.base)
+	if err != nil {
+		return "", withHint(err, "Fix the value of --proxy flag or HTTPS_PROXY/HTTP_PROXY environment variables")
+	}
+
+	details := d.base.String()
+	if d.proxy != nil {
+		details += ", via proxy " + d.proxy.Redacted()
+	}
+	return details, nil
+}
+
+func (d *Doctor) checkDNS(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.params.Timeout)
+	defer cancel()
+
+	host := d.base.Hostname()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		if d.proxy != nil {
+			return "", &warning{hintedError{err, "The name is resolved by the proxy, local resolution failure might be expected"}}
+		}
+		return "", withHint(err, "Check your DNS settings and that "+host+" is the correct address")
+	}
+
+	return fmt.Sprintf("%s resolves to %s", host, strings.Join(addrs, ", ")), nil
+}
+
+func (d *Doctor) checkTCP(ctx context.Context) (string, error) {
+	conn, err := d.dialTCP(ctx)
+	if err != nil {
+		hint := "Check that firewall allows outgoing connections to " + d.hostPort()
+		if d.proxy != nil {
+			hint = "Check that proxy " + d.proxy.Host + " is reachable and allows CONNECT to " + d.hostPort()
+		}
+		return "", withHint(err, hint)
+	}
+	defer conn.Close()
+
+	return fmt.Sprintf("connected to %s from %s", conn.RemoteAddr(), conn.LocalAddr()), nil
+}
+
+func (d *Doctor) checkTLS(ctx context.Context) (string, error) {
+	if d.base.Scheme != "wss" {
+		return "", nil // plain connection, nothing to check
+	}
+
+	conn, err := d.dialTCP(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	cfg, err := d.params.Dial.TLSConfig()
+	if err != nil {
+		return "", err
+	}
+
+	cfg = cfg.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = d.base.Hostname()
+	}
+
+	tlsConn := tls.Client(conn, cfg)
+	_ = tlsConn.SetDeadline(time.Now().Add(d.params.Timeout))
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		var unknownCA x509.UnknownAuthorityError
+		if errors.As(err, &unknownCA) {
+			return "", withHint(err, fmt.Sprintf("Certificate is issued by unknown authority %q, "+
+				"there might be TLS-intercepting proxy in your network, ask your IT for its CA certificate and pass it via --ca-file", issuerName(unknownCA.Cert)))
+		}
+		return "", withHint(err, "Check that nothing in your network interferes with TLS connections to "+d.hostPort())
+	}
+
+	if cfg.InsecureSkipVerify {
+		return "", &warning{hintedError{errors.New("certificate verification is disabled"), "Remove --insecure-skip-tls-verify once the problem is found"}}
+	}
+
+	chain := []string{}
+	for _, cert := range tlsConn.ConnectionState().PeerCertificates {
+		chain = append(chain, cert.Subject.CommonName)
+	}
+	leaf := tlsConn.ConnectionState().PeerCertificates[0]
+	return fmt.Sprintf("chain: %s; issued by %q, expires %s", strings.Join(chain, " <- "), issuerName(leaf), leaf.NotAfter.Format(time.DateOnly)), nil
+}
+
+func (d *Doctor) checkWS(ctx context.Context) (string, error) {
+	transport, err := portforward.DialTransport(ctx, d.params.Cluster, d.params.Token, d.params.timeouts(), d.params.Dial)
+	if err != nil {
+		blocked := "WebSocket upgrade might be blocked by proxy or firewall in your network"
+		if d.params.Dial.Transport == portforward.TransportWebSocket || d.params.Dial.Transport == "" {
+			blocked += ", try --transport auto if Komodor serves HTTP transport for you"
+		}
+
+		hsErr := &portforward.HandshakeError{}
+		if !errors.As(err, &hsErr) {
+			return "", withHint(err, blocked)
+		}
+
+		switch hsErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return "", withHint(err, "Authentication token is invalid or expired, get a fresh one")
+		case http.StatusNotFound:
+			return "", withHint(err, "Check that KOMOCLI_WS_URL points to Komodor WS endpoint")
+		default:
+			return "", withHint(err, blocked)
+		}
+	}
+
+	d.transport = transport
+	return "connection upgraded, token accepted", nil
+}
+
+// agentProbePod is not supposed to exist, the agent answers session init for it with "not found" error
+const agentProbePod = "komocli-doctor-probe"
+
+// checkAgent initializes a session, which only the agent in the cluster can answer, unlike keep-alive.
+// It is best-effort: Komodor has no agent-level endpoint, so answer about the probe pod only shows
+// that the agent is connected, not that it can reach real pods; the trial session checks that.
+func (d *Doctor) checkAgent(_ context.Context) (string, error) {
+	msg := portforward.SessionMessage{
+		MessageId:   uuid.NewString(),
+		MessageType: portforward.MTPortForwardInit,
+		Data:        &portforward.WSPortForwardInitData{Namespace: d.params.Namespace, Resource: agentProbePod, Port: 80},
+		Timestamp:   time.Now(),
+	}
+
+	err := d.send(&msg)
+	if err != nil {
+		return "", err
+	}
+
+	hint := fmt.Sprintf("Check that cluster %q exists and Komodor agent in it is running", d.params.Cluster)
+	bts, err := d.receive(d.params.Timeout)
+	if err != nil {
+		return "", withHint(err, hint)
+	}
+
+	reply := portforward.SessionMessage{}
+	err = json.Unmarshal(bts, &reply)
+	if err != nil {
+		return "", err
+	}
+
+	switch data := reply.Data.(type) {
+	case *portforward.WSAckData:
+		d.terminateProbe(reply.SessionId)
+		return "agent initialized the session", nil
+	case *portforward.WSErrorData:
+		return agentAnswer(data.ErrorMessage, hint)
+	case *portforward.WSSessionTerminationData:
+		return agentAnswer(data.ExitMessage, hint)
+	default:
+		return "", &warning{hintedError{fmt.Errorf("unexpected %s reply to session init", reply.MessageType),
+			"Komodor might be newer than komocli, check for komocli updates"}}
+	}
+}
+
+// agentAnswer tells the agent complaining about missing probe pod from the session failing before reaching it
+func agentAnswer(msg string, hint string) (string, error) {
+	if strings.Contains(msg, "not found") {
+		return "agent answered session init: " + msg + " (best-effort, use --resource to try a real pod)", nil
+	}
+	return "", withHint(errors.New(msg), hint)
+}
+
+func (d *Doctor) terminateProbe(sessionId string) {
+	_ = d.send(&portforward.SessionMessage{
+		MessageId:   uuid.NewString(),
+		SessionId:   sessionId,
+		MessageType: portforward.MTTermination,
+		Data:        &portforward.WSSessionTerminationData{ExitMessage: "connectivity check finished"},
+		Timestamp:   time.Now(),
+	})
+}
+
+func (d *Doctor) send(msg *portforward.SessionMessage) error {
+	bts, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return d.transport.WriteMessage(bts)
+}
+
+// receive reads next message, closing the transport if nothing comes within timeout, since not all transports have read deadlines
+func (d *Doctor) receive(timeout time.Duration) ([]byte, error) {
+	type result struct {
+		bts []byte
+		err error
+	}
+
+	done := make(chan result, 1)
+	transport := d.transport
+	go func() {
+		bts, err := transport.ReadMessage()
+		done <- result{bts, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.bts, res.err
+	case <-time.After(timeout):
+		_ = transport.Close()
+		return nil, fmt.Errorf("no reply within %s", timeout)
+	}
+}
+
+func (d *Doctor) checkPortForward(ctx context.Context) (string, error) {
+	if d.params.Resource == "" {
+		return "", nil // no target to try
+	}
+
+	rSpec := portforward.RemoteSpec{
+		AgentId:    d.params.Cluster,
+		Namespace:  d.params.Namespace,
+		PodName:    d.params.Resource,
+		RemotePort: d.params.Port,
+	}
+
+	ctl := portforward.NewController(rSpec, "", 0, d.params.Token, d.params.timeouts())
+	ctl.Dial = d.params.Dial
+	err := ctl.TestConnection(ctx)
+	if err != nil {
+		return "", withHint(err, "Check that the resource exists and you have permissions in Komodor to port-forward into it")
+	}
+	return "session initialized for " + d.params.target(), nil
+}
+
+// dialTCP connects to WS backend or to the proxy, tunneling through it
+func (d *Doctor) dialTCP(ctx context.Context) (net.Conn, error) {
+	return d.params.Dial.DialTCP(ctx, d.proxy, d.hostPort(), d.params.Timeout)
+}
+
+func (d *Doctor) hostPort() string {
+	return portforward.HostPortOf(d.base)
+}
+
+func issuerName(cert *x509.Certificate) string {
+	if cert == nil {
+		return "unknown"
+	}
+	if len(cert.Issuer.Organization) > 0 {
+		return cert.Issuer.Organization[0]
+	}
+	return cert.Issuer.CommonName
+}
@@ -0,0 +1,229 @@
+package doctor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/portforward"
+	"github.com/spf13/cobra"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+const flagToken = "token"
+const flagCluster = "cluster"
+const flagNamespace = "namespace"
+const flagResource = "resource"
+const flagPort = "port"
+const flagTimeout = "timeout"
+const flagReport = "report"
+
+var (
+	doctorLong = templates.LongDesc(`
+		Diagnose connectivity to Komodor.
+
+		Checks each layer in turn: DNS resolution of WS endpoint, TCP and TLS connection to it,
+		WebSocket upgrade (or HTTP transport, if selected) with authentication, presence of the agent in the cluster (best-effort,
+		by asking it for a pod that does not exist) and, optionally, initialization of port-forward session to the given resource.`)
+
+	doctorExample = templates.Examples(`
+		# Check connectivity to the cluster
+		komocli doctor --cluster my-cluster --token=...
+
+		# Also try to start port-forward session to the pod, and save JSON report to attach to support ticket
+		komocli doctor --cluster my-cluster --namespace default --resource pod/mypod --port 5000 --report report.json --token=...`)
+)
+
+type CmdParams struct {
+	Token      string
+	Cluster    string
+	Namespace  string
+	Resource   string
+	Port       int
+	Timeout    time.Duration
+	ReportFile string
+	Version    string
+	Dial       *portforward.DialOptions
+}
+
+func (p *CmdParams) AcceptArgs(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.Token, err = flags.GetString(flagToken)
+	if err != nil {
+		return err
+	}
+
+	if p.Token == "" {
+		p.Token = os.Getenv("KOMOCLI_JWT")
+	}
+
+	if p.Token == "" {
+		return errors.New("authentication token is required")
+	}
+
+	p.Cluster, err = flags.GetString(flagCluster)
+	if err != nil {
+		return err
+	}
+
+	p.Namespace, err = flags.GetString(flagNamespace)
+	if err != nil {
+		return err
+	}
+
+	p.Resource, err = flags.GetString(flagResource)
+	if err != nil {
+		return err
+	}
+
+	p.Port, err = flags.GetInt(flagPort)
+	if err != nil {
+		return err
+	}
+
+	if p.Resource != "" && p.Port == 0 {
+		return fmt.Errorf("--%s is required together with --%s", flagPort, flagResource)
+	}
+
+	p.Timeout, err = flags.GetDuration(flagTimeout)
+	if err != nil {
+		return err
+	}
+
+	p.ReportFile, err = flags.GetString(flagReport)
+	if err != nil {
+		return err
+	}
+
+	p.Dial, err = portforward.AcceptDialFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	p.Version = os.Getenv("KOMOCLI_VERSION")
+	return nil
+}
+
+func (p *CmdParams) target() string {
+	if p.Resource == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s:%d", p.Namespace, p.Resource, p.Port)
+}
+
+func (p *CmdParams) timeouts() portforward.Timeouts {
+	return portforward.NewTimeouts(p.Timeout, portforward.Timeouts{})
+}
+
+func (p *CmdParams) Run(cmd *cobra.Command) error {
+	doc := Doctor{params: p}
+	report := doc.Run(cmd.Context())
+
+	printReport(cmd.OutOrStdout(), report)
+
+	if p.ReportFile != "" {
+		err := writeReport(p.ReportFile, cmd.OutOrStdout(), report)
+		if err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+
+	if report.Failed() {
+		return errors.New("some of the checks have failed")
+	}
+	return nil
+}
+
+func printReport(out io.Writer, report *Report) {
+	for _, c := range report.Checks {
+		_, _ = fmt.Fprintf(out, "[%s] %s", statusLabel(c.Status), c.Name)
+		if c.Details != "" {
+			_, _ = fmt.Fprintf(out, ": %s", c.Details)
+		}
+		if c.Error != "" {
+			_, _ = fmt.Fprintf(out, ": %s", c.Error)
+		}
+		_, _ = fmt.Fprintln(out)
+	}
+
+	hints := []string{}
+	for _, c := range report.Checks {
+		if c.Hint != "" {
+			hints = append(hints, fmt.Sprintf("  - %s: %s", c.Name, c.Hint))
+		}
+	}
+
+	if len(hints) > 0 {
+		_, _ = fmt.Fprintln(out, "\nHow to fix:")
+		for _, h := range hints {
+			_, _ = fmt.Fprintln(out, h)
+		}
+	}
+}
+
+func statusLabel(s Status) string {
+	switch s {
+	case StatusPass:
+		return "PASS"
+	case StatusWarn:
+		return "WARN"
+	case StatusFail:
+		return "FAIL"
+	default:
+		return "SKIP"
+	}
+}
+
+func writeReport(path string, stdout io.Writer, report *Report) error {
+	bts, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if path == "-" {
+		_, err = fmt.Fprintln(stdout, string(bts))
+		return err
+	}
+	return os.WriteFile(path, bts, 0o644)
+}
+
+func NewCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:     "doctor",
+		Short:   "Diagnose connectivity to Komodor",
+		Long:    doctorLong,
+		Example: doctorExample,
+		Args:    cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := CmdParams{}
+			err := opts.AcceptArgs(c)
+			if err != nil {
+				return err
+			}
+
+			return opts.Run(c)
+		},
+	}
+
+	setupFlags(cmd)
+	err := cmd.MarkFlagRequired(flagCluster)
+	if err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+func setupFlags(cmd *cobra.Command) {
+	cmd.Flags().String(flagToken, "", "JWT Authentication token")
+	cmd.Flags().String(flagCluster, "", "Komodor cluster name to check")
+	cmd.Flags().String(flagNamespace, "default", "Namespace for the trial port-forward resource")
+	cmd.Flags().String(flagResource, "", "Resource to try port-forward session with, like pod/mypod")
+	cmd.Flags().Int(flagPort, 0, "Remote port for the trial port-forward session")
+	cmd.Flags().Duration(flagTimeout, 5*time.Second, "Timeout for each check")
+	cmd.Flags().String(flagReport, "", "Write JSON report into this file, '-' for stdout")
+	portforward.SetupDialFlags(cmd)
+}
@@ -0,0 +1,95 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/komodorio/komocli/pkg/internal/hubtest"
+	"github.com/komodorio/komocli/pkg/portforward"
+)
+
+// newHub answers the first message of each connection with the reply
+func newHub(t *testing.T, reply func(msg *portforward.SessionMessage) *portforward.SessionMessage) {
+	ws := hubtest.NewHandler(t, func(conn *websocket.Conn, msg *hubtest.Message) bool {
+		sessMsg := portforward.SessionMessage{}
+		if err := msg.Decode(&sessMsg); err != nil {
+			t.Errorf("failed to decode message: %s", err)
+			return false
+		}
+		_ = conn.WriteJSON(reply(&sessMsg))
+		return false
+	})
+
+	hubtest.Serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("authorization") != "good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ws.ServeHTTP(w, r)
+	}))
+	t.Setenv("KOMOCLI_DEV", "1")
+}
+
+func TestDoctor(t *testing.T) {
+	newHub(t, func(msg *portforward.SessionMessage) *portforward.SessionMessage {
+		return &portforward.SessionMessage{MessageType: portforward.MTAck, Data: &portforward.WSAckData{AckedMessageID: msg.MessageId}}
+	})
+
+	cases := []struct {
+		token    string
+		statuses []Status
+	}{
+		{token: "good", statuses: []Status{StatusPass, StatusPass, StatusPass, StatusSkip, StatusPass, StatusPass, StatusSkip}},
+		{token: "bad", statuses: []Status{StatusPass, StatusPass, StatusPass, StatusSkip, StatusFail, StatusSkip, StatusSkip}},
+	}
+
+	for _, c := range cases {
+		doc := Doctor{params: &CmdParams{Token: c.token, Cluster: "test", Timeout: time.Second, Dial: &portforward.DialOptions{}}}
+		report := doc.Run(context.Background())
+
+		for i, res := range report.Checks {
+			if res.Status != c.statuses[i] {
+				t.Errorf("unexpected status for check %q with token %q: %s (%s)", res.Name, c.token, res.Status, res.Error)
+			}
+		}
+
+		out := bytes.Buffer{}
+		printReport(&out, report)
+		if report.Failed() && !strings.Contains(out.String(), "How to fix") {
+			t.Errorf("failed report is expected to contain hints: %s", out.String())
+		}
+	}
+}
+
+func TestCheckAgent(t *testing.T) {
+	cases := []struct {
+		reply  *portforward.SessionMessage
+		status Status
+	}{
+		{reply: &portforward.SessionMessage{MessageType: portforward.MTError, Data: &portforward.WSErrorData{ErrorMessage: `pods "komocli-doctor-probe" not found`}}, status: StatusPass},
+		{reply: &portforward.SessionMessage{MessageType: portforward.MTError, Data: &portforward.WSErrorData{ErrorMessage: "agent is not connected"}}, status: StatusFail},
+		{reply: &portforward.SessionMessage{MessageType: portforward.MTKeepAlive, Data: &portforward.WSKeepaliveData{}}, status: StatusWarn},
+	}
+
+	for _, c := range cases {
+		newHub(t, func(msg *portforward.SessionMessage) *portforward.SessionMessage {
+			if msg.MessageType != portforward.MTPortForwardInit {
+				t.Errorf("agent is expected to be probed with session init, got %s", msg.MessageType)
+			}
+			return c.reply
+		})
+
+		doc := Doctor{params: &CmdParams{Token: "good", Cluster: "test", Timeout: time.Second, Dial: &portforward.DialOptions{}}}
+		report := doc.Run(context.Background())
+
+		res := report.Checks[5]
+		if res.Status != c.status {
+			t.Errorf("unexpected status of %q for %s reply: %s (%s)", res.Name, c.reply.MessageType, res.Status, res.Error)
+		}
+	}
+}
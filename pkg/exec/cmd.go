@@ -0,0 +1,505 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/portforward"
+	"github.com/komodorio/komocli/pkg/recordings"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+const flagToken = "token"
+const flagCluster = "cluster"
+const flagNamespace = "namespace"
+const flagContainer = "container"
+const flagStdin = "stdin"
+const flagTTY = "tty"
+const flagWeb = "web"
+const flagAddress = "address"
+const flagPort = "port"
+const flagTimeout = "timeout"
+const flagCommandTimeout = "command-timeout"
+const flagParallel = "parallel"
+const flagOutputDir = "output-dir"
+const flagRecord = "record"
+const flagEscapeChar = "escape-char"
+const flagWorkDir = "workdir"
+const flagEnv = "env"
+
+const defaultCommand = "sh"
+
+var (
+	execLong = templates.LongDesc(`
+		Execute a command in a container.
+
+		The command defaults to 'sh'. Use -i to pass stdin to the container and -t to treat it as a terminal,
+		like kubectl does. Without -t, no terminal is allocated in the container, unless Komodor is too old to
+		support choosing it, and output is passed as is, so it can be redirected into a file.
+
+		End of stdin is not passed to the container, Komodor has no way to signal it. Commands reading their input
+		until its end, like 'cat > file' with input from a file or a pipe, don't exit on their own then,
+		use 'komocli cp' for files or --command-timeout to stop them.
+
+		komocli exits with the exit code of the command, or 130 when interrupted. Its arguments are passed as is,
+		spaces included, shell-quoted for older Komodor versions that take the command as a single string.
+		--workdir and --env set up the process by running the command through sh and env in the container,
+		so they work with every Komodor version, as long as the container has these tools.
+
+		With -t, typing ~. right after newline closes the session even if the remote process hangs,
+		~# shows session info and ~? lists the escape sequences. --escape-char changes ~ or disables them.
+
+		With --web, a terminal page is served locally and opened in browser instead, each page opens its own session.
+
+		When several pods are given before '--', the command runs in each of them, one session per pod.
+		Output lines are prefixed with the pod name, or written into per-pod files with --output-dir,
+		and a table of exit codes is printed at the end. Pods can't be selected by labels, Komodor sessions
+		can't list them.
+
+		--record writes the session in asciicast v2 format, see 'komocli recordings'. Sessions into clusters
+		matching KOMOCLI_RECORD_CLUSTERS patterns are always recorded into the directory of recordings.`)
+
+	execExample = templates.Examples(`
+		# Open interactive shell in the pod
+		komocli exec -it pod/mypod --namespace default --cluster my-cluster --token=...
+
+		# Run bash in specific container
+		komocli exec -it pod/mypod -c app --namespace default --cluster my-cluster --token=... -- bash
+
+		# Copy a file from the container, failing if it takes more than a minute
+		komocli exec pod/mypod --command-timeout 1m --namespace default --cluster my-cluster --token=... -- cat /etc/config.yaml > local.yaml
+
+		# Open the shell in browser terminal
+		komocli exec --web pod/mypod --namespace default --cluster my-cluster --token=...
+
+		# Run the script with arguments containing spaces, in the app directory
+		komocli exec pod/mypod --workdir /app --env MODE=dry-run --env "NAME=two words" --namespace default --cluster my-cluster --token=... -- ./migrate.sh "my table"
+
+		# Record the shell session for the audit
+		komocli exec -it pod/mypod --record session.cast --namespace default --cluster my-cluster --token=...
+
+		# Check memory of every replica, saving the output into files per pod
+		komocli exec pod/web-1 pod/web-2 pod/web-3 --output-dir ./meminfo --namespace default --cluster my-cluster --token=... -- cat /proc/meminfo`)
+)
+
+type CmdParams struct {
+	Target         Target
+	Pods           []string // more than one pod runs the command in each of them
+	Token          string
+	Timeout        time.Duration
+	CommandTimeout time.Duration // limits the whole session, 0 means unlimited
+	Stdin          bool
+	TTY            bool
+	EscapeChar     byte // starts escape sequences in TTY mode, 0 disables them
+	Web            bool
+	Address        string
+	Port           int
+	Parallel       int
+	OutputDir      string
+	Record         string // cast file, sessions may still be recorded automatically without it
+	Dial           *portforward.DialOptions
+}
+
+func (p *CmdParams) AcceptArgs(cmd *cobra.Command, args []string) (err error) {
+	err = p.acceptTargets(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	flags := cmd.Flags()
+	p.Token, err = flags.GetString(flagToken)
+	if err != nil {
+		return err
+	}
+
+	if p.Token == "" {
+		p.Token = os.Getenv("KOMOCLI_JWT")
+	}
+
+	for flag, dst := range map[string]*string{flagCluster: &p.Target.Cluster, flagNamespace: &p.Target.Namespace, flagContainer: &p.Target.Container} {
+		*dst, err = flags.GetString(flag)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.Timeout, err = flags.GetDuration(flagTimeout)
+	if err != nil {
+		return err
+	}
+
+	p.CommandTimeout, err = flags.GetDuration(flagCommandTimeout)
+	if err != nil {
+		return err
+	}
+
+	if p.CommandTimeout < 0 {
+		return errors.New("command timeout can't be negative")
+	}
+
+	err = p.acceptTerminalFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	err = p.acceptFanOutFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	err = p.acceptRecordFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	err = p.acceptProcessFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	p.Dial, err = portforward.AcceptDialFlags(cmd)
+	return err
+}
+
+// acceptTargets takes the pods before '--' and the command after it. Without '--', the first arg is the pod
+func (p *CmdParams) acceptTargets(cmd *cobra.Command, args []string) error {
+	pods, command := args[:1], args[1:]
+	if dash := cmd.ArgsLenAtDash(); dash > 0 {
+		pods, command = args[:dash], args[dash:]
+	}
+
+	seen := map[string]bool{}
+	for _, resource := range pods {
+		pod, err := PodName(resource)
+		if err != nil {
+			return err
+		}
+
+		if seen[pod] {
+			return fmt.Errorf("pod %s is given more than once", pod)
+		}
+		seen[pod] = true
+		p.Pods = append(p.Pods, pod)
+	}
+	p.Target.Pod = p.Pods[0]
+
+	p.Target.Command = command
+	if len(p.Target.Command) == 0 {
+		p.Target.Command = []string{defaultCommand}
+	}
+	return nil
+}
+
+func (p *CmdParams) acceptFanOutFlags(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.Parallel, err = flags.GetInt(flagParallel)
+	if err != nil {
+		return err
+	}
+
+	if p.Parallel < 1 {
+		return fmt.Errorf("--%s has to be at least 1", flagParallel)
+	}
+
+	p.OutputDir, err = flags.GetString(flagOutputDir)
+	if err != nil {
+		return err
+	}
+
+	if len(p.Pods) > 1 && (p.Stdin || p.TTY || p.Web) {
+		return fmt.Errorf("input can't be passed to several pods, --%s, --%s and --%s take a single pod", flagStdin, flagTTY, flagWeb)
+	}
+	return nil
+}
+
+func (p *CmdParams) acceptProcessFlags(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.Target.WorkDir, err = flags.GetString(flagWorkDir)
+	if err != nil {
+		return err
+	}
+
+	p.Target.Env, err = flags.GetStringArray(flagEnv)
+	if err != nil {
+		return err
+	}
+
+	for _, env := range p.Target.Env {
+		key, _, found := strings.Cut(env, "=")
+		if !found || key == "" {
+			return fmt.Errorf("environment variable has to be KEY=VALUE, got %q", env)
+		}
+	}
+	return nil
+}
+
+func (p *CmdParams) acceptRecordFlags(cmd *cobra.Command) (err error) {
+	p.Record, err = cmd.Flags().GetString(flagRecord)
+	if err != nil {
+		return err
+	}
+
+	if p.Record != "" && (p.Web || len(p.Pods) > 1) {
+		return fmt.Errorf("--%s takes a single session, it can't be combined with --%s or several pods", flagRecord, flagWeb)
+	}
+	return nil
+}
+
+func (p *CmdParams) acceptTerminalFlags(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.Stdin, err = flags.GetBool(flagStdin)
+	if err != nil {
+		return err
+	}
+
+	p.TTY, err = flags.GetBool(flagTTY)
+	if err != nil {
+		return err
+	}
+
+	p.Web, err = flags.GetBool(flagWeb)
+	if err != nil {
+		return err
+	}
+
+	p.Address, err = flags.GetString(flagAddress)
+	if err != nil {
+		return err
+	}
+
+	p.Port, err = flags.GetInt(flagPort)
+	if err != nil {
+		return err
+	}
+
+	escape, err := flags.GetString(flagEscapeChar)
+	if err != nil {
+		return err
+	}
+
+	p.EscapeChar, err = parseEscapeChar(escape)
+	if err != nil {
+		return err
+	}
+
+	if p.Web && (p.Stdin || p.TTY) {
+		return fmt.Errorf("--%s takes input from the page, it can't be combined with --%s or --%s", flagWeb, flagStdin, flagTTY)
+	}
+	return nil
+}
+
+func (p *CmdParams) newSession(ctx context.Context) (*Session, error) {
+	target := p.Target
+	target.TTY = p.TTY || p.Web // local TTY mode may have been turned off after the flags were read
+	sess := NewSession(ctx, target, p.Token, portforward.NewTimeouts(p.Timeout, portforward.Timeouts{}), p.Dial)
+	file, err := p.recordingFile()
+	if err != nil || file == "" {
+		return sess, err
+	}
+
+	sess.Record, err = recordings.Create(file, p.castHeader())
+	if err != nil {
+		return nil, fmt.Errorf("failed to start recording the session: %w", err)
+	}
+
+	log.Infof("Recording the session into %s", file)
+	return sess, nil
+}
+
+// recordingFile is either the one given, or a new one for clusters that are always recorded
+func (p *CmdParams) recordingFile() (string, error) {
+	if p.Record != "" {
+		return p.Record, nil
+	}
+
+	always, err := recordings.AlwaysRecord(p.Target.Cluster)
+	if err != nil || !always {
+		return "", err
+	}
+	return recordings.NewFile(time.Now(), p.Target.Cluster, p.Target.Namespace, p.Target.Pod)
+}
+
+func (p *CmdParams) castHeader() recordings.Header {
+	hdr := recordings.Header{Width: 80, Height: 24, Env: map[string]string{"TERM": os.Getenv("TERM")}}
+	if p.TTY {
+		if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			hdr.Width, hdr.Height = w, h
+		}
+	}
+
+	t := p.Target
+	hdr.Title = path.Join(t.Cluster, t.Namespace, t.Pod, t.Container) + ": " + strings.Join(t.Command, " ")
+	return hdr
+}
+
+func (p *CmdParams) Run(ctx context.Context) error {
+	if p.Web {
+		return p.serveWeb(ctx)
+	}
+
+	if len(p.Pods) > 1 {
+		if !log.IsLevelEnabled(log.DebugLevel) {
+			log.SetLevel(log.WarnLevel)
+		}
+		return p.runAll(ctx, os.Stdout, os.Stderr)
+	}
+	return p.runLocal(ctx)
+}
+
+// runLocal bridges the session with stdin and stdout of komocli
+func (p *CmdParams) runLocal(ctx context.Context) error {
+	var stdin io.Reader
+	if p.Stdin {
+		stdin = os.Stdin
+	}
+
+	if p.TTY && !term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Warnf("Unable to use a TTY, input is not a terminal")
+		p.TTY = false
+	}
+
+	if p.Stdin && !term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Warnf("End of input can't be passed to the container, the command has to exit on its own")
+	}
+
+	if !log.IsLevelEnabled(log.DebugLevel) {
+		log.SetLevel(log.WarnLevel) // info lines would get in the way of the remote terminal, or of scripts reading stderr
+	}
+
+	if p.TTY {
+		restore, err := rawTerminal(os.Stdin)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	return p.execute(ctx, stdin, os.Stdout)
+}
+
+// execute runs the session within command timeout, returning ExitError for non-zero exit code
+func (p *CmdParams) execute(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+	var cancel context.CancelFunc
+	if p.CommandTimeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, p.CommandTimeout, fmt.Errorf("command did not finish within %s", p.CommandTimeout))
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	ctx, closeSession := context.WithCancelCause(ctx)
+	defer closeSession(nil)
+
+	sess, err := p.newSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	if p.TTY {
+		go syncSize(ctx, os.Stdout, sess)
+	}
+
+	if p.TTY && p.EscapeChar != 0 && stdin != nil {
+		stdin = newEscapeReader(stdin, p.EscapeChar, stdout, func() { closeSession(errEscapeClose) }, func() string { return p.sessionInfo(sess) })
+	}
+
+	err = sess.Run(stdin, stdout)
+	if errors.Is(err, errEscapeClose) {
+		return nil
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return &ExitError{Code: ExitCodeInterrupted}
+	}
+
+	if err != nil {
+		return fmt.Errorf("error while executing command: %w", err)
+	}
+
+	if code := sess.ExitCode(); code != 0 {
+		return &ExitError{Code: code}
+	}
+	return nil
+}
+
+// sessionInfo is shown by escape sequence
+func (p *CmdParams) sessionInfo(sess *Session) string {
+	stats, t := sess.Stats(), p.Target
+	latency := "unknown"
+	if stats.AckRTT > 0 {
+		latency = stats.AckRTT.Round(time.Millisecond).String()
+	}
+
+	return strings.Join([]string{
+		"Session: " + stats.SessionId,
+		"Cluster: " + t.Cluster,
+		"Pod:     " + path.Join(t.Namespace, t.Pod, t.Container),
+		"Latency: " + latency,
+		fmt.Sprintf("Uptime:  %s, sent %s, received %s", stats.Age.Round(time.Second), portforward.FormatBytes(stats.BytesUp), portforward.FormatBytes(stats.BytesDown)),
+	}, "\n")
+}
+
+func NewCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:     "exec POD [POD...] [-- COMMAND [args...]]",
+		Short:   "Execute a command in a container",
+		Long:    execLong,
+		Example: execExample,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := CmdParams{}
+			err := opts.AcceptArgs(c, args)
+			if err != nil {
+				return err
+			}
+
+			return opts.Run(c.Context())
+		},
+	}
+
+	setupFlags(cmd)
+	err := validateFlags(cmd)
+	if err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+func setupFlags(cmd *cobra.Command) {
+	cmd.Flags().String(flagToken, "", "JWT Authentication token")
+	cmd.Flags().String(flagCluster, "", "Komodor cluster name that contains the pod")
+	cmd.Flags().String(flagNamespace, "default", "Namespace of the pod")
+	cmd.Flags().StringP(flagContainer, "c", "", "Container name, defaults to the first container of the pod")
+	cmd.Flags().BoolP(flagStdin, "i", false, "Pass stdin to the container")
+	cmd.Flags().BoolP(flagTTY, "t", false, "Stdin is a terminal, switch it into raw mode and keep the remote terminal size in sync")
+	cmd.Flags().String(flagEscapeChar, "~", "Escape character for sequences like ~. in -t mode, ^X for control characters, or "+escapeNone+" to disable them")
+	cmd.Flags().Bool(flagWeb, false, "Serve browser terminal page locally and open it, instead of using stdin and stdout")
+	cmd.Flags().String(flagAddress, "localhost", "Network address to serve --"+flagWeb+" page on")
+	cmd.Flags().Int(flagPort, 0, "Port to serve --"+flagWeb+" page on, random by default")
+	cmd.Flags().Duration(flagTimeout, 5*time.Second, "Timeout for connecting and acknowledgements of the session")
+	cmd.Flags().Duration(flagCommandTimeout, 0, "Stop the command if it did not finish within this time, 0 means unlimited")
+	cmd.Flags().Int(flagParallel, 10, "How many pods to run the command in at once, when several pods are given")
+	cmd.Flags().String(flagWorkDir, "", "Working directory of the command in the container, changed into with sh")
+	cmd.Flags().StringArray(flagEnv, nil, "Environment variable KEY=VALUE for the command, set with env, can be repeated")
+	cmd.Flags().String(flagRecord, "", "Record the session into this file in asciicast v2 format")
+	cmd.Flags().String(flagOutputDir, "", "Write output of each pod into <pod>.out file in this directory, instead of prefixed lines on stdout")
+	portforward.SetupDialFlags(cmd)
+}
+
+func validateFlags(cmd *cobra.Command) error {
+	err := cmd.MarkFlagRequired(flagCluster)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParams(t *testing.T) {
+	cmd := NewCommand()
+	err := cmd.ParseFlags([]string{"--cluster", "test", "-i", "--command-timeout", "1m", "-c", "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := CmdParams{}
+	err = p.AcceptArgs(cmd, []string{"pod/mypod", "cat", "/etc/hosts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Target.Pod != "mypod" || p.Target.Container != "app" || strings.Join(p.Target.Command, " ") != "cat /etc/hosts" || !p.Stdin || p.TTY || p.CommandTimeout != time.Minute {
+		t.Errorf("unexpected params: %+v", p)
+	}
+
+	env := NewCommand()
+	_ = env.ParseFlags([]string{"--cluster", "test", "--workdir", "/app", "--env", "A=1,2", "--env", "B="})
+	p = CmdParams{}
+	err = p.AcceptArgs(env, []string{"mypod"})
+	if err != nil || p.Target.WorkDir != "/app" || strings.Join(p.Target.Env, ";") != "A=1,2;B=" {
+		t.Errorf("unexpected process params: %+v (%v)", p.Target, err)
+	}
+
+	malformed := NewCommand()
+	_ = malformed.ParseFlags([]string{"--cluster", "test", "--env", "=1"})
+	if err := (&CmdParams{}).AcceptArgs(malformed, []string{"mypod"}); err == nil {
+		t.Errorf("environment variable without a name is not supposed to be accepted")
+	}
+
+	web := NewCommand()
+	_ = web.ParseFlags([]string{"--cluster", "test", "--web", "-t"})
+	if err := (&CmdParams{}).AcceptArgs(web, []string{"mypod"}); err == nil {
+		t.Errorf("--web is not supposed to be combined with -t")
+	}
+}
+
+func TestParamsSeveralPods(t *testing.T) {
+	cmd := NewCommand()
+	err := cmd.ParseFlags([]string{"--cluster", "test", "pod/web-1", "web-2", "--", "cat", "/proc/meminfo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := CmdParams{}
+	err = p.AcceptArgs(cmd, cmd.Flags().Args())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(p.Pods, ",") != "web-1,web-2" || strings.Join(p.Target.Command, " ") != "cat /proc/meminfo" || p.Parallel != 10 {
+		t.Errorf("unexpected params: %+v", p)
+	}
+
+	tty := NewCommand()
+	_ = tty.ParseFlags([]string{"--cluster", "test", "-it", "web-1", "web-2", "--", "sh"})
+	if err := (&CmdParams{}).AcceptArgs(tty, tty.Flags().Args()); err == nil {
+		t.Errorf("-it is not supposed to be accepted for several pods")
+	}
+}
+
+func newTestParams() *CmdParams {
+	return &CmdParams{Target: Target{Cluster: "test", Namespace: "default", Pod: "mypod", Command: []string{"sh"}}, Token: "token", Timeout: time.Second}
+}
+
+func TestExecuteExitCode(t *testing.T) {
+	newExecHub(t)
+
+	binary := make([]byte, 256)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+
+	stdin, input := io.Pipe()
+	go func() {
+		_, _ = input.Write(binary)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = input.Write([]byte("exit"))
+	}()
+
+	out := bytes.Buffer{}
+	err := newTestParams().execute(context.Background(), stdin, &out)
+
+	exitErr := &ExitError{}
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Errorf("expected exit code 3, got %v", err)
+	}
+
+	if !bytes.Equal(out.Bytes(), binary) {
+		t.Errorf("output is not binary-safe: %v", out.Bytes())
+	}
+}
+
+func TestExecuteCommandTimeout(t *testing.T) {
+	newExecHub(t)
+
+	p := newTestParams()
+	p.CommandTimeout = 200 * time.Millisecond
+
+	started := time.Now()
+	err := p.execute(context.Background(), nil, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "did not finish within 200ms") {
+		t.Errorf("expected command timeout, got %v", err)
+	}
+
+	if time.Since(started) > 2*time.Second {
+		t.Errorf("command timeout took too long: %s", time.Since(started))
+	}
+}
+
+func TestExecuteInterrupted(t *testing.T) {
+	hub := newExecHub(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	err := newTestParams().execute(ctx, nil, io.Discard)
+	exitErr := &ExitError{}
+	if !errors.As(err, &exitErr) || exitErr.Code != ExitCodeInterrupted {
+		t.Errorf("expected exit code %d, got %v", ExitCodeInterrupted, err)
+	}
+
+	hub.mx.Lock()
+	defer hub.mx.Unlock()
+	if hub.init == nil || hub.init.TTY {
+		t.Errorf("session without -t is not supposed to ask for a terminal: %+v", hub.init)
+	}
+}
+
+func TestExecuteRecord(t *testing.T) {
+	hub := newExecHub(t)
+	hub.batch = true
+
+	p := newTestParams()
+	p.Record = filepath.Join(t.TempDir(), "session.cast")
+	err := p.execute(context.Background(), nil, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(p.Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 2 || !strings.Contains(lines[0], `"title":"test/default/mypod: sh"`) || !strings.Contains(string(data), `"o","hello from\nmypod\n"]`) {
+		t.Errorf("unexpected recording:\n%s", data)
+	}
+}
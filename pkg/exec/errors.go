@@ -0,0 +1,15 @@
+package exec
+
+import "fmt"
+
+// ExitCodeInterrupted is returned when komocli is stopped before the command ends, like shells do for SIGINT
+const ExitCodeInterrupted = 130
+
+// ExitError reports non-zero exit code of the remote command, komocli exits with the same code
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command terminated with exit code %d", e.Code)
+}
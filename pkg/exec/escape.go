@@ -0,0 +1,126 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// escapeNone disables escape sequences
+const escapeNone = "none"
+
+var errEscapeClose = errors.New("session was closed with escape sequence")
+
+// parseEscapeChar accepts a single character, control one as ^X, or none
+func parseEscapeChar(s string) (byte, error) {
+	switch {
+	case s == escapeNone:
+		return 0, nil
+	case len(s) == 1 && s[0] < 0x80:
+		return s[0], nil
+	case len(s) == 2 && s[0] == '^' && s[1] >= '@' && s[1] <= '_':
+		return s[1] & 0x1f, nil
+	}
+	return 0, fmt.Errorf("escape character has to be a single ASCII character, ^X or %q, got %q", escapeNone, s)
+}
+
+// escapeReader watches typed input for escape sequences right after newline, like ssh does.
+// Recognized sequences are taken out of the input, the escape character typed twice is passed once
+type escapeReader struct {
+	r         io.Reader
+	char      byte
+	out       io.Writer // local terminal in raw mode
+	stop      func()
+	info      func() string
+	lineStart bool
+	escaped   bool // the escape character was typed, waiting for the command
+	pending   []byte
+	err       error
+}
+
+func newEscapeReader(r io.Reader, char byte, out io.Writer, stop func(), info func() string) *escapeReader {
+	return &escapeReader{r: r, char: char, out: out, stop: stop, info: info, lineStart: true}
+}
+
+func (e *escapeReader) Read(b []byte) (int, error) {
+	for len(e.pending) == 0 && e.err == nil {
+		n, err := e.r.Read(b)
+		e.pending, e.err = e.filter(b[:n]), err
+	}
+
+	if len(e.pending) == 0 {
+		return 0, e.err
+	}
+
+	n := copy(b, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+func (e *escapeReader) filter(in []byte) []byte {
+	out := make([]byte, 0, len(in)+1)
+	for _, c := range in {
+		switch {
+		case e.escaped:
+			e.escaped = false
+			if e.command(c) {
+				continue
+			}
+
+			out = append(out, e.char)
+			if c == e.char {
+				e.lineStart = false
+				continue
+			}
+		case e.lineStart && c == e.char:
+			e.escaped = true
+			continue
+		}
+
+		out = append(out, c)
+		e.lineStart = c == '\r' || c == '\n'
+	}
+	return out
+}
+
+// command runs the escape sequence, returns false for characters that are not commands
+func (e *escapeReader) command(c byte) bool {
+	switch c {
+	case '.':
+		e.print("Closing the session")
+		e.stop()
+	case '?':
+		e.print(e.help())
+	case '#':
+		e.print(e.info())
+	default:
+		return false
+	}
+	e.lineStart = true
+	return true
+}
+
+func (e *escapeReader) help() string {
+	ch := escapeName(e.char)
+	return strings.Join([]string{
+		"Supported escape sequences:",
+		" " + ch + ".   - close the session",
+		" " + ch + "#   - show session info",
+		" " + ch + "?   - this message",
+		" " + ch + ch + "  - send the escape character by typing it twice",
+		"(Note that escapes are only recognized immediately after newline.)",
+	}, "\n")
+}
+
+// print writes the message on its own lines, terminal in raw mode needs \r for that
+func (e *escapeReader) print(msg string) {
+	_, _ = io.WriteString(e.out, "\r\n"+strings.ReplaceAll(msg, "\n", "\r\n")+"\r\n")
+}
+
+func escapeName(c byte) string {
+	if c < 0x20 {
+		return "^" + string(rune(c+'@'))
+	}
+	return string(rune(c))
+}
@@ -0,0 +1,93 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer takes both the output and the messages of escape sequences
+type syncBuffer struct {
+	mx  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.buf.String()
+}
+
+func TestParseEscapeChar(t *testing.T) {
+	for s, expected := range map[string]byte{"~": '~', "^]": 0x1d, "none": 0} {
+		if c, err := parseEscapeChar(s); err != nil || c != expected {
+			t.Errorf("%s: expected %d, got %d (%v)", s, expected, c, err)
+		}
+	}
+
+	for _, s := range []string{"", "~~", "é", "^a"} {
+		if _, err := parseEscapeChar(s); err == nil {
+			t.Errorf("%q is not supposed to be accepted", s)
+		}
+	}
+}
+
+func TestEscapeReader(t *testing.T) {
+	cases := map[string]string{
+		"ls\r~~x\r": "ls\r~x\r",
+		"a~.b":      "a~.b", // not after newline
+		"~?~#ls\r":  "ls\r",
+		"~x":        "~x",
+		"\r~":       "\r", // waiting for the command
+	}
+
+	for input, expected := range cases {
+		out := bytes.Buffer{}
+		stopped := false
+		r := newEscapeReader(strings.NewReader(input), '~', &out, func() { stopped = true }, func() string { return "Session: sess-1" })
+
+		passed, err := io.ReadAll(r)
+		if err != nil || string(passed) != expected || stopped {
+			t.Errorf("%q: expected %q to be passed, got %q (%v)", input, expected, passed, err)
+		}
+
+		if strings.Contains(input, "~?") && (!strings.Contains(out.String(), "~.   - close the session\r\n") || !strings.Contains(out.String(), "Session: sess-1")) {
+			t.Errorf("expected help and session info, got %q", out.String())
+		}
+	}
+}
+
+func TestExecuteEscapeClose(t *testing.T) {
+	newExecHub(t)
+
+	p := newTestParams()
+	p.TTY = true
+	p.EscapeChar = '~'
+
+	stdin, input := io.Pipe()
+	go func() {
+		_, _ = input.Write([]byte("echo\r"))
+		time.Sleep(100 * time.Millisecond)
+		_, _ = input.Write([]byte("~."))
+	}()
+
+	out := &syncBuffer{}
+	err := p.execute(context.Background(), stdin, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "echo\r\r\nClosing the session\r\n" {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+}
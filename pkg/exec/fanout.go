@@ -0,0 +1,158 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// maxPartialLine is how much of unterminated line is held back before writing it out anyway
+const maxPartialLine = 64 * 1024
+
+// podResult is the outcome of the command in one of the pods
+type podResult struct {
+	Pod      string
+	ExitCode int
+	Duration time.Duration
+	Err      error // the session failed, so there is no exit code
+}
+
+// runAll executes the command in each of the pods, at most Parallel at once. Output lines are prefixed with
+// the pod name, or written into per-pod files in OutputDir. Exit codes are summed up in a table on stderr
+func (p *CmdParams) runAll(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
+	if p.OutputDir != "" {
+		err := os.MkdirAll(p.OutputDir, 0o755)
+		if err != nil {
+			return err
+		}
+	}
+
+	results := make([]podResult, len(p.Pods))
+	slots := make(chan struct{}, p.Parallel)
+	outMx := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i, pod := range p.Pods {
+		wg.Add(1)
+		go func(i int, pod string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			results[i] = p.runInPod(ctx, pod, &linePrefixer{mx: &outMx, out: stdout, prefix: []byte("[" + pod + "] ")})
+		}(i, pod)
+	}
+	wg.Wait()
+
+	failed := printResults(stderr, results)
+	if failed > 0 {
+		return fmt.Errorf("command failed in %d of %d pods", failed, len(results))
+	}
+	return nil
+}
+
+func (p *CmdParams) runInPod(ctx context.Context, pod string, prefixed *linePrefixer) (res podResult) {
+	res.Pod = pod
+	started := time.Now()
+	defer func() { res.Duration = time.Since(started) }()
+
+	var out io.Writer = prefixed
+	if p.OutputDir != "" {
+		f, err := os.Create(filepath.Join(p.OutputDir, pod+".out"))
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		defer f.Close()
+		out = f
+	} else {
+		defer prefixed.flush()
+	}
+
+	q := *p
+	q.Target.Pod = pod
+	err := q.execute(ctx, nil, out)
+
+	exitErr := &ExitError{}
+	if errors.As(err, &exitErr) {
+		res.ExitCode = exitErr.Code
+	} else {
+		res.Err = err
+	}
+	return res
+}
+
+// printResults writes the table of exit codes, returning the number of pods where the command failed
+func printResults(out io.Writer, results []podResult) (failed int) {
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "POD\tEXIT CODE\tDURATION\tERROR")
+	for _, res := range results {
+		code, msg := strconv.Itoa(res.ExitCode), ""
+		if res.Err != nil {
+			code, msg = "-", res.Err.Error()
+		}
+
+		if res.Err != nil || res.ExitCode != 0 {
+			failed++
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.Pod, code, res.Duration.Round(time.Millisecond), msg)
+	}
+	_ = tw.Flush()
+	return failed
+}
+
+// linePrefixer writes whole lines with the prefix, so that output of different pods doesn't mix within a line
+type linePrefixer struct {
+	mx      *sync.Mutex // shared by all pods writing into out
+	out     io.Writer
+	prefix  []byte
+	partial []byte
+}
+
+func (w *linePrefixer) Write(b []byte) (int, error) {
+	w.partial = append(w.partial, b...)
+	end := bytes.LastIndexByte(w.partial, '\n') + 1
+	if end == 0 {
+		if len(w.partial) >= maxPartialLine {
+			w.flush() // too long to hold back, break it
+		}
+		return len(b), nil
+	}
+
+	err := w.writeLines(w.partial[:end])
+	w.partial = append(w.partial[:0], w.partial[end:]...)
+	return len(b), err
+}
+
+// flush writes out the last line, even if the output did not end with a newline
+func (w *linePrefixer) flush() {
+	if len(w.partial) > 0 {
+		_ = w.writeLines(append(w.partial, '\n'))
+		w.partial = nil
+	}
+}
+
+func (w *linePrefixer) writeLines(b []byte) error {
+	buf := bytes.Buffer{}
+	for len(b) > 0 {
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line = b[:i+1]
+		}
+		buf.Write(w.prefix)
+		buf.Write(line)
+		b = b[len(line):]
+	}
+
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	_, err := w.out.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,79 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRunAll(t *testing.T) {
+	hub := newExecHub(t)
+	hub.batch = true
+	hub.exitCodes = map[string]int{"web-2": 7}
+
+	p := newTestParams()
+	p.Pods = []string{"web-1", "web-2", "web-3"}
+	p.Parallel = 2
+
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	err := p.runAll(context.Background(), &stdout, &stderr)
+	if err == nil || err.Error() != "command failed in 1 of 3 pods" {
+		t.Errorf("expected failure in one pod, got %v", err)
+	}
+
+	for _, pod := range p.Pods {
+		if !strings.Contains(stdout.String(), "["+pod+"] hello from\n["+pod+"] "+pod+"\n") {
+			t.Errorf("no prefixed output of %s: %q", pod, stdout.String())
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "POD") || strings.Fields(lines[2])[1] != "7" {
+		t.Errorf("unexpected table of exit codes:\n%s", stderr.String())
+	}
+}
+
+func TestRunAllOutputDir(t *testing.T) {
+	hub := newExecHub(t)
+	hub.batch = true
+
+	p := newTestParams()
+	p.Pods = []string{"web-1", "web-2"}
+	p.Parallel = 1
+	p.OutputDir = filepath.Join(t.TempDir(), "out")
+
+	stdout := bytes.Buffer{}
+	err := p.runAll(context.Background(), &stdout, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout.Len() > 0 {
+		t.Errorf("output is supposed to go into files, got %q", stdout.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(p.OutputDir, "web-2.out"))
+	if err != nil || string(data) != "hello from\nweb-2\n" {
+		t.Errorf("unexpected output file: %q (%v)", data, err)
+	}
+}
+
+func TestLinePrefixer(t *testing.T) {
+	out := bytes.Buffer{}
+	w := &linePrefixer{mx: &sync.Mutex{}, out: &out, prefix: []byte("[a] ")}
+
+	_, _ = w.Write([]byte("one\ntw"))
+	_, _ = w.Write([]byte("o\nthree"))
+	if out.String() != "[a] one\n[a] two\n" {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+
+	w.flush()
+	if out.String() != "[a] one\n[a] two\n[a] three\n" {
+		t.Errorf("unexpected output after flush: %q", out.String())
+	}
+}
@@ -0,0 +1,30 @@
+#!/bin/sh
+# Fetches xterm.js files served by the web terminal from npm registry into assets,
+# checking the packages against the integrity published in the registry
+set -eu
+cd "$(dirname "$0")/assets"
+
+fetch() { # package version files...
+  pkg=$1
+  ver=$2
+  shift 2
+
+  tgz=$(mktemp)
+  trap 'rm -f "$tgz"' EXIT
+  curl -fsSL "https://registry.npmjs.org/$pkg/-/${pkg#*/}-$ver.tgz" -o "$tgz"
+
+  expected=$(curl -fsSL "https://registry.npmjs.org/$pkg/$ver" | sed -n 's/.*"integrity":"\(sha512-[^"]*\)".*/\1/p')
+  actual="sha512-$(openssl dgst -sha512 -binary "$tgz" | base64 | tr -d '\n')"
+  if [ "$expected" != "$actual" ]; then
+    echo "integrity of $pkg@$ver does not match: expected $expected, got $actual" >&2
+    exit 1
+  fi
+
+  for f in "$@"; do
+    tar -xzf "$tgz" -O "package/$f" > "$(basename "$f")"
+  done
+  rm -f "$tgz"
+}
+
+fetch @xterm/xterm 5.5.0 lib/xterm.js css/xterm.css
+fetch @xterm/addon-fit 0.10.0 lib/addon-fit.js
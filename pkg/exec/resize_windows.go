@@ -0,0 +1,29 @@
+package exec
+
+import (
+	"context"
+	"time"
+)
+
+const resizePollInterval = 250 * time.Millisecond
+
+// notifyResize signals when the size of the terminal may have changed, Windows has no signal for it so we poll
+func notifyResize(ctx context.Context) <-chan struct{} {
+	res := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(resizePollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case res <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return res
+}
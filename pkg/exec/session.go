@@ -0,0 +1,151 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/komodorio/komocli/pkg/portforward"
+	"github.com/komodorio/komocli/pkg/recordings"
+)
+
+// Target is the container to run the command in
+type Target struct {
+	Cluster   string
+	Namespace string
+	Pod       string
+	Container string
+	Command   []string
+	WorkDir   string
+	Env       []string // KEY=VALUE
+	TTY       bool     // asks for a terminal in the container, otherwise the output is passed byte for byte
+}
+
+func (t *Target) initMessage() portforward.SessionMessage {
+	return portforward.SessionMessage{
+		MessageType: portforward.MTPodExecInit,
+		Data: &portforward.WSPodExecInitData{
+			Namespace:     t.Namespace,
+			PodName:       t.Pod,
+			ContainerName: t.Container,
+			Cmd:           t.legacyCmd(),
+			TTY:           t.TTY,
+			Argv:          t.argv(),
+		},
+	}
+}
+
+// workDirScript changes into the directory given as its first argument and runs the rest of them
+const workDirScript = `cd "$1" && shift && exec "$@"`
+
+// argv sets up the process with tools of the container, sh for working directory and env for variables,
+// since Komodor takes only the command. This way every Komodor version runs it the same way
+func (t *Target) argv() []string {
+	argv := t.Command
+	if len(t.Env) > 0 {
+		argv = append(append([]string{"env"}, t.Env...), argv...)
+	}
+
+	if t.WorkDir != "" {
+		argv = append([]string{"sh", "-c", workDirScript, "sh", t.WorkDir}, argv...)
+	}
+	return argv
+}
+
+// legacyCmd is the command for hubs that take it as a single string
+func (t *Target) legacyCmd() string {
+	argv := t.argv()
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote leaves plain arguments as they are, so that hubs splitting the command by spaces get the same words
+func shellQuote(arg string) string {
+	plain := arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune("-_./:=@%+,", r))
+	}) < 0
+	if plain {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// PodName accepts pod/name, pods/name or just the name, exec is possible only into pods
+func PodName(resource string) (string, error) {
+	kind, name, found := strings.Cut(resource, "/")
+	if !found {
+		return resource, nil
+	}
+
+	if kind != "pod" && kind != "pods" || name == "" {
+		return "", fmt.Errorf("exec is only possible into a pod, got %q", resource)
+	}
+	return name, nil
+}
+
+// Session runs the command in the container, bridging local streams with it
+type Session struct {
+	ws   *portforward.WSConnectionWrapper
+	conn net.Conn // our end of the pipe that the wrapper bridges with the session
+
+	Record *recordings.Cast // optional, gets the output and resizes, closed when Run returns
+}
+
+func NewSession(ctx context.Context, target Target, jwt string, timeouts portforward.Timeouts, dial *portforward.DialOptions) *Session {
+	conn, bridged := net.Pipe()
+	ws := portforward.NewWSConnectionWrapper(ctx, bridged, target.Cluster, jwt, false, target.initMessage(), timeouts)
+	ws.Dial = dial
+	return &Session{ws: ws, conn: conn}
+}
+
+// Run bridges the streams until the session ends, stdin is optional
+func (s *Session) Run(stdin io.Reader, stdout io.Writer) error {
+	if stdin != nil {
+		go func() {
+			_, _ = io.Copy(s.conn, stdin) // end of input does not end the session, the process does
+		}()
+	}
+
+	if s.Record != nil {
+		stdout = io.MultiWriter(stdout, s.Record)
+	}
+
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		_, _ = io.Copy(stdout, s.conn)
+	}()
+
+	err := s.ws.Run() // closes the other end of the pipe when done
+	<-copied
+
+	if s.Record != nil {
+		recErr := s.Record.Close()
+		if recErr != nil && err == nil {
+			err = fmt.Errorf("failed to record the session: %w", recErr)
+		}
+	}
+	return err
+}
+
+func (s *Session) Resize(width, height uint16) error {
+	err := s.ws.Resize(width, height)
+	if err == nil && s.Record != nil {
+		s.Record.Resize(width, height)
+	}
+	return err
+}
+
+func (s *Session) Stats() portforward.ConnStats {
+	return s.ws.Stats()
+}
+
+// ExitCode of the process, valid once Run has returned
+func (s *Session) ExitCode() int {
+	return s.ws.ExitCode()
+}
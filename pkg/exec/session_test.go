@@ -0,0 +1,199 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/komodorio/komocli/pkg/internal/hubtest"
+	"github.com/komodorio/komocli/pkg/portforward"
+)
+
+// execHub stands in for Komodor backend: echoes stdin back as stdout, and exits with code 3 on "exit" input
+type execHub struct {
+	mx       sync.Mutex
+	init     *portforward.WSPodExecInitData
+	resizes  []portforward.WSTerminalSizeData
+	exitCode int
+
+	// batch answers init right away with the pod name as output, and the exit code from exitCodes
+	batch     bool
+	exitCodes map[string]int
+}
+
+func newExecHub(t *testing.T) *execHub {
+	hub := &execHub{exitCode: 3}
+	hubtest.Start(t, func(conn *websocket.Conn, msg *hubtest.Message) bool {
+		sessMsg := portforward.SessionMessage{}
+		if err := msg.Decode(&sessMsg); err != nil {
+			t.Errorf("failed to decode message: %s", err)
+			return false
+		}
+
+		if sessMsg.MessageType == portforward.MTTermination {
+			return false
+		}
+		return hub.handle(conn, &sessMsg)
+	})
+	return hub
+}
+
+func (h *execHub) handle(conn *websocket.Conn, msg *portforward.SessionMessage) bool {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	switch data := msg.Data.(type) {
+	case *portforward.WSPodExecInitData:
+		h.init = data
+		if h.batch {
+			out := base64.StdEncoding.EncodeToString([]byte("hello from\n" + data.PodName + "\n"))
+			_ = hubtest.Reply(conn, string(portforward.MTAck), &portforward.WSAckData{AckedMessageID: msg.MessageId})
+			_ = hubtest.Reply(conn, string(portforward.MTStdout), &portforward.WSStdoutData{Out: out})
+			_ = hubtest.Reply(conn, string(portforward.MTTermination), &portforward.WSSessionTerminationData{ProcessExitCode: h.exitCodes[data.PodName]})
+			return false
+		}
+	case *portforward.WSTerminalSizeData:
+		h.resizes = append(h.resizes, *data)
+		return true // not acked
+	case *portforward.WSStdinData:
+		input, _ := base64.StdEncoding.DecodeString(data.Input)
+		_ = hubtest.Reply(conn, string(portforward.MTAck), &portforward.WSAckData{AckedMessageID: msg.MessageId})
+		if strings.TrimSpace(string(input)) == "exit" {
+			_ = hubtest.Reply(conn, string(portforward.MTTermination), &portforward.WSSessionTerminationData{ProcessExitCode: h.exitCode})
+			return false
+		}
+		_ = hubtest.Reply(conn, string(portforward.MTStdout), &portforward.WSStdoutData{Out: data.Input})
+		return true
+	}
+	_ = hubtest.Reply(conn, string(portforward.MTAck), &portforward.WSAckData{AckedMessageID: msg.MessageId})
+	return true
+}
+
+func TestPodName(t *testing.T) {
+	for resource, expected := range map[string]string{"mypod": "mypod", "pod/mypod": "mypod", "pods/mypod": "mypod"} {
+		if name, err := PodName(resource); err != nil || name != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", resource, expected, name, err)
+		}
+	}
+
+	if _, err := PodName("deployment/web"); err == nil {
+		t.Errorf("exec into deployment is not supposed to be accepted")
+	}
+}
+
+func TestSession(t *testing.T) {
+	hub := newExecHub(t)
+
+	target := Target{Cluster: "test", Namespace: "default", Pod: "mypod", Container: "app", Command: []string{"cat", "/etc/my hosts"}, WorkDir: "/app", Env: []string{"A=b c"}}
+	sess := NewSession(context.Background(), target, "token", portforward.NewTimeouts(time.Second, portforward.Timeouts{}), nil)
+
+	stdin, input := io.Pipe()
+	go func() {
+		_, _ = input.Write([]byte("hello\n"))
+		time.Sleep(100 * time.Millisecond)
+		_, _ = input.Write([]byte("exit\n"))
+	}()
+
+	out := bytes.Buffer{}
+	err := sess.Run(stdin, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "hello\n" {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+
+	if sess.ExitCode() != 3 {
+		t.Errorf("expected exit code 3, got %d", sess.ExitCode())
+	}
+
+	if hub.init == nil || hub.init.PodName != "mypod" || hub.init.ContainerName != "app" {
+		t.Errorf("unexpected init message: %+v", hub.init)
+	}
+
+	argv := []string{"sh", "-c", workDirScript, "sh", "/app", "env", "A=b c", "cat", "/etc/my hosts"}
+	if !slices.Equal(hub.init.Argv, argv) || hub.init.Cmd != `sh -c 'cd "$1" && shift && exec "$@"' sh /app env 'A=b c' cat '/etc/my hosts'` {
+		t.Errorf("working directory or environment are not set up by the command: %+v", hub.init)
+	}
+}
+
+func TestWebTerminal(t *testing.T) {
+	hub := newExecHub(t)
+
+	p := &CmdParams{Target: Target{Cluster: "test", Namespace: "default", Pod: "mypod", Command: []string{"sh"}}, Token: "token", Timeout: time.Second, Web: true}
+	w := &webTerminal{params: p, ctx: context.Background(), key: "secret"}
+	srv := httptest.NewServer(w.handler())
+	defer srv.Close()
+
+	if strings.Contains(string(terminalHTML), "https://") {
+		t.Errorf("the page is not supposed to load anything from other sites")
+	}
+
+	page, err := http.Get(srv.URL + "/assets/xterm.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = page.Body.Close()
+	if page.StatusCode != http.StatusOK {
+		t.Errorf("expected embedded assets to be served, got %d", page.StatusCode)
+	}
+
+	wsURL := strings.Replace(srv.URL, "http", "ws", 1) + "/ws?key="
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"wrong", nil)
+	if err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected page with wrong key to be refused, got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_ = conn.WriteJSON(&webResize{Cols: 120, Rows: 40})
+	_ = conn.WriteMessage(websocket.BinaryMessage, []byte("hello\n"))
+
+	_, data, err := conn.ReadMessage()
+	if err != nil || string(data) != "hello\n" {
+		t.Fatalf("expected echoed input, got %q (%v)", data, err)
+	}
+
+	_ = conn.WriteMessage(websocket.BinaryMessage, []byte("exit\n"))
+	exit := webExit{}
+	err = conn.ReadJSON(&exit)
+	if err != nil || exit.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %+v (%v)", exit, err)
+	}
+
+	hub.mx.Lock()
+	defer hub.mx.Unlock()
+	if len(hub.resizes) != 1 || hub.resizes[0].Width != 120 || hub.resizes[0].Height != 40 {
+		t.Errorf("unexpected resizes: %v", hub.resizes)
+	}
+
+	if !hub.init.TTY {
+		t.Errorf("page session is expected to ask for a terminal")
+	}
+}
+
+func TestLegacyCmd(t *testing.T) {
+	target := Target{Command: []string{"sh", "-c", "echo 'hi' > /tmp/my file", "", "--opt=a,b"}}
+	if cmd := target.legacyCmd(); cmd != `sh -c 'echo '\''hi'\'' > /tmp/my file' '' --opt=a,b` {
+		t.Errorf("unexpected legacy command: %s", cmd)
+	}
+
+	target.Env = []string{"A=1"}
+	if cmd := target.legacyCmd(); !strings.HasPrefix(cmd, "env A=1 sh -c ") {
+		t.Errorf("older hubs are expected to get the same setup: %s", cmd)
+	}
+}
@@ -0,0 +1,47 @@
+package exec
+
+import (
+	"context"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+// rawTerminal switches local terminal into raw mode, so that keys like Ctrl+C reach the remote process
+func rawTerminal(in *os.File) (restore func(), err error) {
+	fd := int(in.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		err := term.Restore(fd, state)
+		if err != nil {
+			log.Warnf("Failed to restore terminal: %s", err)
+		}
+	}, nil
+}
+
+// syncSize sends the size of local terminal to the session initially and on each change, until the context is done
+func syncSize(ctx context.Context, out *os.File, sess *Session) {
+	changes := notifyResize(ctx)
+	var width, height int
+	for {
+		w, h, err := term.GetSize(int(out.Fd()))
+		if err == nil && (w != width || h != height) {
+			width, height = w, h
+			err = sess.Resize(uint16(width), uint16(height))
+			if err != nil {
+				log.Debugf("Failed to send terminal size: %s", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package exec
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/browser"
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed terminal.html
+var terminalHTML []byte
+
+// assets are the files of the page, served locally instead of loading them from CDN. They are not committed,
+// so the build fails until they are fetched, rather than shipping a blank page
+//
+//go:generate sh fetch-assets.sh
+//go:embed assets/xterm.js assets/xterm.css assets/addon-fit.js
+var assets embed.FS
+
+var errWebTerminalClosed = errors.New("web terminal was closed")
+
+// webResize is sent by the page as text message when its terminal changes size, data goes in binary messages
+type webResize struct {
+	Cols uint16 `json:"cols"`
+	Rows uint16 `json:"rows"`
+}
+
+// webExit is sent to the page as text message when the session ends
+type webExit struct {
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+}
+
+// webTerminal serves xterm.js page, each page connection runs its own exec session
+type webTerminal struct {
+	params   *CmdParams
+	ctx      context.Context
+	key      string // part of the page URL, so that other web sites can't open sessions
+	upgrader websocket.Upgrader
+}
+
+func (p *CmdParams) serveWeb(ctx context.Context) error {
+	key := make([]byte, 16)
+	_, err := rand.Read(key)
+	if err != nil {
+		return err
+	}
+
+	listen, err := net.Listen("tcp", net.JoinHostPort(p.Address, fmt.Sprint(p.Port)))
+	if err != nil {
+		return err
+	}
+
+	w := &webTerminal{params: p, ctx: ctx, key: hex.EncodeToString(key)}
+	srv := &http.Server{Handler: w.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	url := fmt.Sprintf("http://%s/?key=%s", listen.Addr(), w.key)
+	log.Infof("Serving web terminal for %s on %s", p.Target.Pod, url)
+	err = browser.OpenURL(url)
+	if err != nil {
+		log.Warnf("Failed to open Web browser: %s", err)
+	}
+
+	err = srv.Serve(listen)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (w *webTerminal) handler() http.Handler {
+	files, _ := fs.Sub(assets, "assets") // can't fail for embedded directory
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.GET("/", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", terminalHTML)
+	})
+	router.StaticFS("/assets", http.FS(files))
+	router.GET("/ws", w.authorize, w.bridge)
+	return router
+}
+
+func (w *webTerminal) authorize(c *gin.Context) {
+	if subtle.ConstantTimeCompare([]byte(c.Query("key")), []byte(w.key)) != 1 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.Next()
+}
+
+// bridge runs exec session for the page until either of them ends
+func (w *webTerminal) bridge(c *gin.Context) {
+	conn, err := w.upgrader.Upgrade(c.Writer, c.Request, nil) // default origin check refuses other web sites
+	if err != nil {
+		log.Warnf("Failed to upgrade web terminal connection: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancelCause(w.ctx)
+	defer cancel(nil)
+
+	sess, err := w.params.newSession(ctx)
+	if err != nil {
+		log.Errorf("Failed to start web terminal session: %s", err)
+		_ = conn.WriteJSON(&webExit{Error: err.Error()})
+		return
+	}
+
+	stdin, input := io.Pipe()
+	go func() {
+		err := w.readPage(conn, sess, input)
+		cancel(err)
+	}()
+
+	log.Infof("Web terminal connected from %s", conn.RemoteAddr())
+	err = sess.Run(stdin, &pageWriter{conn: conn})
+	_ = stdin.Close() // unblocks the page reader, nobody takes the input anymore
+
+	exit := webExit{ExitCode: sess.ExitCode()}
+	if err != nil && !errors.Is(err, errWebTerminalClosed) {
+		exit.Error = err.Error()
+	}
+	_ = conn.WriteJSON(&exit)
+	log.Infof("Web terminal from %s finished with exit code %d", conn.RemoteAddr(), exit.ExitCode)
+}
+
+// readPage passes keys typed in the page into stdin and its resizes into the session
+func (w *webTerminal) readPage(conn *websocket.Conn, sess *Session, input *io.PipeWriter) error {
+	defer input.Close()
+	for {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			return errWebTerminalClosed
+		}
+
+		if kind == websocket.BinaryMessage {
+			_, err = input.Write(data)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		size := webResize{}
+		err = json.Unmarshal(data, &size)
+		if err != nil {
+			log.Debugf("Ignoring malformed message from web terminal: %s", err)
+			continue
+		}
+
+		err = sess.Resize(size.Cols, size.Rows)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// pageWriter sends process output to the page
+type pageWriter struct {
+	conn *websocket.Conn
+}
+
+func (p *pageWriter) Write(b []byte) (int, error) {
+	err := p.conn.WriteMessage(websocket.BinaryMessage, b)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,88 @@
+// Package hubtest stands in for Komodor WS hub in tests. It does not import portforward,
+// so that tests of portforward itself can use it, too
+package hubtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// SessionId is the session ID the stand-in puts into its replies
+const SessionId = "sess-1"
+
+// Message is a session message received from the client, Raw keeps it whole for decoding into portforward types
+type Message struct {
+	MessageId   string `json:"messageId"`
+	SessionId   string `json:"sessionId"`
+	MessageType string `json:"messageType"`
+	Raw         []byte `json:"-"`
+}
+
+// Decode unmarshals the whole message, usually into portforward.SessionMessage
+func (m *Message) Decode(v any) error {
+	return json.Unmarshal(m.Raw, v)
+}
+
+// Handler answers the message, returning false to close the connection
+type Handler func(conn *websocket.Conn, msg *Message) bool
+
+// Start serves the stand-in for the rest of the test, pointing KOMOCLI_WS_URL at it
+func Start(t *testing.T, handle Handler) *httptest.Server {
+	return Serve(t, NewHandler(t, handle))
+}
+
+// Serve serves the handler for the rest of the test, pointing KOMOCLI_WS_URL at it
+func Serve(t *testing.T, h http.Handler) *httptest.Server {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	t.Setenv("KOMOCLI_WS_URL", strings.Replace(srv.URL, "http", "ws", 1))
+	return srv
+}
+
+// NewHandler upgrades each request to WebSocket and passes the messages from it to handle
+func NewHandler(t *testing.T, handle Handler) http.Handler {
+	upgrader := websocket.Upgrader{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, bts, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			msg := Message{Raw: bts}
+			err = json.Unmarshal(bts, &msg)
+			if err != nil {
+				t.Errorf("malformed message from the client: %s", err)
+				return
+			}
+
+			if !handle(conn, &msg) {
+				return
+			}
+		}
+	})
+}
+
+// AckAll acknowledges every message until the client terminates the session
+func AckAll(conn *websocket.Conn, msg *Message) bool {
+	if msg.MessageType == "termination" {
+		return false
+	}
+	return Reply(conn, "ack", map[string]string{"ackedMessageID": msg.MessageId}) == nil
+}
+
+// Reply sends a message of the type with the data, in the session of the stand-in
+func Reply(conn *websocket.Conn, msgType string, data any) error {
+	return conn.WriteJSON(map[string]any{"sessionId": SessionId, "messageType": msgType, "data": data})
+}
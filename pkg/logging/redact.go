@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const redacted = "***"
+
+// LogPayloads disables truncation of session data in debug logs, it may contain customer data
+var LogPayloads = false
+
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// JWTs wherever they appear
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), redacted},
+	// query params, cookies and environment variables carrying credentials, like DB_PASSWORD=
+	{regexp.MustCompile(`(?i)\b(\w*(?:authorization|token|secret|password|passwd|api_?key))=[^&;\s"'\\]+`), "${1}=" + redacted},
+	// authentication headers
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`), "${1} " + redacted},
+	{regexp.MustCompile(`(?i)\b(cookie|x-komocli-secret)(\\?"?\s*[:=]\s*\\?"?)[^"\s\\[]+`), "${1}${2}" + redacted},
+	// passwords in URLs, like proxy ones
+	{regexp.MustCompile(`(://[^:/@\s]+):[^@/\s]+@`), "${1}:" + redacted + "@"},
+}
+
+var payloadPattern = regexp.MustCompile(`"(input|out)":"([^"]*)"`)
+
+// commandPattern matches the command of exec session, which may carry secrets in its --env variables
+var commandPattern = regexp.MustCompile(`"(cmd|argv)":("(?:[^"\\]|\\.)*"|\[(?:"(?:[^"\\]|\\.)*",?)*\])`)
+
+// Redact masks tokens, secrets and cookie values in the text
+func Redact(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// Payload prepares session message for logging, data and command are replaced with their size unless LogPayloads is set
+func Payload(bts []byte) string {
+	if LogPayloads {
+		return string(bts)
+	}
+
+	s := payloadPattern.ReplaceAllStringFunc(string(bts), func(field string) string {
+		m := payloadPattern.FindStringSubmatch(field)
+		return fmt.Sprintf(`"%s":"<%d bytes of base64>"`, m[1], len(m[2]))
+	})
+	return commandPattern.ReplaceAllStringFunc(s, func(field string) string {
+		m := commandPattern.FindStringSubmatch(field)
+		return fmt.Sprintf(`"%s":"<%d bytes of command>"`, m[1], len(m[2]))
+	})
+}
+
+// RedactingFormatter masks secrets in the output of wrapped formatter, no matter where they came from
+type RedactingFormatter struct {
+	log.Formatter
+}
+
+func (f *RedactingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	bts, err := f.Formatter.Format(entry)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(Redact(string(bts))), nil
+}
+
+// InstallRedaction wraps the formatter of standard logger, it has to be called after formatter changes
+func InstallRedaction() {
+	logger := log.StandardLogger()
+	if _, ok := logger.Formatter.(*RedactingFormatter); ok {
+		return
+	}
+	log.SetFormatter(&RedactingFormatter{Formatter: logger.Formatter})
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configure the standard logger
+type Options struct {
+	Format     string // FormatText or FormatJSON
+	Level      string // any of logrus levels, like "info" or "debug"
+	File       string // log into this file instead of stderr
+	MaxSizeMB  int    // rotate the file when it grows bigger
+	MaxBackups int    // number of rotated files to keep
+}
+
+// Configure applies the options to the standard logger, keeping secrets redacted
+func Configure(opts Options) error {
+	level, err := log.ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+
+	var formatter log.Formatter
+	switch opts.Format {
+	case FormatText:
+		formatter = &log.TextFormatter{DisableColors: opts.File != ""}
+	case FormatJSON:
+		formatter = &log.JSONFormatter{}
+	default:
+		return fmt.Errorf("unsupported log format %q, use %s or %s", opts.Format, FormatText, FormatJSON)
+	}
+
+	var out io.Writer = os.Stderr
+	if opts.File != "" {
+		out = &lumberjack.Logger{
+			Filename:   opts.File,
+			MaxSize:    opts.MaxSizeMB,
+			MaxBackups: opts.MaxBackups,
+		}
+	}
+
+	log.SetLevel(level)
+	log.SetOutput(out)
+	log.SetFormatter(&RedactingFormatter{Formatter: formatter})
+	return nil
+}
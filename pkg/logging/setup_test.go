@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestConfigure(t *testing.T) {
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFormatter(&RedactingFormatter{Formatter: &log.TextFormatter{}})
+		log.SetLevel(log.InfoLevel)
+	}()
+
+	path := filepath.Join(t.TempDir(), "komocli.log")
+	err := Configure(Options{Format: FormatJSON, Level: "warn", File: path, MaxSizeMB: 1, MaxBackups: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.Infof("filtered out")
+	log.WithField("session_id", "s1").Warnf("connecting with ?authorization=secret")
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := map[string]string{}
+	err = json.Unmarshal(bts, &line)
+	if err != nil {
+		t.Fatalf("expected single JSON line, got %s: %s", bts, err)
+	}
+
+	if line["session_id"] != "s1" || line["msg"] != "connecting with ?authorization=***" {
+		t.Errorf("unexpected log line: %s", bts)
+	}
+
+	for _, opts := range []Options{{Format: "xml", Level: "info"}, {Format: FormatText, Level: "loud"}} {
+		if Configure(opts) == nil {
+			t.Errorf("invalid options are expected to fail: %+v", opts)
+		}
+	}
+}
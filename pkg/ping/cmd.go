@@ -0,0 +1,258 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/portforward"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+const flagToken = "token"
+const flagCluster = "cluster"
+const flagCount = "count"
+const flagInterval = "interval"
+const flagTimeout = "timeout"
+const flagType = "type"
+const flagNamespace = "namespace"
+const flagResource = "resource"
+const flagPort = "port"
+
+var (
+	pingLong = templates.LongDesc(`
+		Measure round-trip time to Komodor WS backend and the agent.
+
+		Sends messages over single WebSocket connection and times their acknowledgements,
+		printing min/avg/max/jitter statistics like ping(8) does.
+
+		With --resource and --port, port-forward session is initialized first, so the messages travel within the session.
+		Compare the results with and without the session to tell if slowness comes from Komodor path or from the pod.`)
+
+	pingExample = templates.Examples(`
+		# Send 10 pings to the cluster
+		komocli ping --cluster my-cluster -c 10 --token=...
+
+		# Use keep-alive messages within port-forward session to the pod
+		komocli ping --cluster my-cluster --type keep-alive --resource pod/mypod --port 5000 --token=...`)
+)
+
+type CmdParams struct {
+	Token     string
+	Cluster   string
+	Count     int
+	Interval  time.Duration
+	Timeout   time.Duration
+	Type      portforward.MessageType
+	Namespace string
+	Resource  string
+	Port      int
+	Dial      *portforward.DialOptions
+}
+
+func (p *CmdParams) AcceptArgs(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.Token, err = flags.GetString(flagToken)
+	if err != nil {
+		return err
+	}
+
+	if p.Token == "" {
+		p.Token = os.Getenv("KOMOCLI_JWT")
+	}
+
+	p.Cluster, err = flags.GetString(flagCluster)
+	if err != nil {
+		return err
+	}
+
+	p.Count, err = flags.GetInt(flagCount)
+	if err != nil {
+		return err
+	}
+
+	p.Interval, err = flags.GetDuration(flagInterval)
+	if err != nil {
+		return err
+	}
+
+	p.Timeout, err = flags.GetDuration(flagTimeout)
+	if err != nil {
+		return err
+	}
+
+	if p.Count < 0 || p.Interval <= 0 || p.Timeout <= 0 {
+		return errors.New("count can't be negative, interval and timeout have to be positive")
+	}
+
+	msgType, err := flags.GetString(flagType)
+	if err != nil {
+		return err
+	}
+
+	p.Type = portforward.MessageType(msgType)
+	if p.Type != portforward.MTPing && p.Type != portforward.MTKeepAlive {
+		return fmt.Errorf("unsupported message type %q, use %s or %s", msgType, portforward.MTPing, portforward.MTKeepAlive)
+	}
+
+	return p.acceptTargetFlags(cmd)
+}
+
+func (p *CmdParams) acceptTargetFlags(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.Namespace, err = flags.GetString(flagNamespace)
+	if err != nil {
+		return err
+	}
+
+	p.Resource, err = flags.GetString(flagResource)
+	if err != nil {
+		return err
+	}
+
+	p.Port, err = flags.GetInt(flagPort)
+	if err != nil {
+		return err
+	}
+
+	if p.Resource != "" && p.Port == 0 {
+		return fmt.Errorf("--%s is required together with --%s", flagPort, flagResource)
+	}
+
+	p.Dial, err = portforward.AcceptDialFlags(cmd)
+	return err
+}
+
+func (p *CmdParams) Run(ctx context.Context, out io.Writer) error {
+	initMsg := portforward.SessionMessage{
+		MessageType: portforward.MTPortForwardInit,
+		Data: &portforward.WSPortForwardInitData{
+			Namespace: p.Namespace,
+			Resource:  p.Resource,
+			Port:      p.Port,
+		},
+	}
+
+	timeouts := portforward.NewTimeouts(p.Timeout, portforward.Timeouts{})
+	ws := portforward.NewWSConnectionWrapper(ctx, nil, p.Cluster, p.Token, true, initMsg, timeouts)
+	ws.Dial = p.Dial
+	err := ws.Open(p.Resource != "")
+	if err != nil {
+		return fmt.Errorf("failed to open connection: %w", err)
+	}
+	defer func() {
+		err := ws.Stop()
+		if err != nil {
+			log.Debugf("Failed to stop session: %s", err)
+		}
+	}()
+
+	_, _ = fmt.Fprintf(out, "PING %s via %s, using %s messages\n", p.Cluster, portforward.WSBaseURL(), p.Type)
+
+	started := time.Now()
+	sent := 0
+	for seq := 1; p.Count == 0 || seq <= p.Count; seq++ {
+		if seq > 1 && !sleep(ctx, p.Interval) {
+			break
+		}
+
+		sent++
+		rtt, err := ws.Probe(p.Type, p.Timeout)
+		if ctx.Err() != nil {
+			sent-- // interrupted, not lost
+			break
+		}
+
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "no ack from %s: seq=%d %s\n", p.Cluster, seq, err)
+			if ws.Err() != nil { // session is over, no point to continue
+				break
+			}
+			continue
+		}
+		_, _ = fmt.Fprintf(out, "ack from %s: seq=%d time=%s\n", p.Cluster, seq, formatMs(rtt))
+	}
+
+	printSummary(out, p.Cluster, sent, &ws.Latency, time.Since(started))
+
+	if ws.Latency.Count() == 0 && sent > 0 {
+		return errors.New("no acks received")
+	}
+	return nil
+}
+
+func printSummary(out io.Writer, cluster string, sent int, stats *portforward.LatencyStats, elapsed time.Duration) {
+	acked := stats.Count()
+	loss := 0.0
+	if sent > 0 {
+		loss = 100 * float64(sent-acked) / float64(sent)
+	}
+
+	_, _ = fmt.Fprintf(out, "\n--- %s ping statistics ---\n", cluster)
+	_, _ = fmt.Fprintf(out, "%d messages sent, %d acked, %.1f%% loss, time %dms\n", sent, acked, loss, elapsed.Milliseconds())
+	if acked > 0 {
+		_, _ = fmt.Fprintf(out, "rtt min/avg/max/jitter = %s/%s/%s/%s\n",
+			formatMs(stats.Min()), formatMs(stats.Avg()), formatMs(stats.Max()), formatMs(stats.Jitter()))
+	}
+}
+
+func formatMs(d time.Duration) string {
+	return fmt.Sprintf("%.3f ms", float64(d.Microseconds())/1000)
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func NewCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:     "ping",
+		Short:   "Measure round-trip time to Komodor and the agent",
+		Long:    pingLong,
+		Example: pingExample,
+		Args:    cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := CmdParams{}
+			err := opts.AcceptArgs(c)
+			if err != nil {
+				return err
+			}
+
+			return opts.Run(c.Context(), c.OutOrStdout())
+		},
+	}
+
+	setupFlags(cmd)
+	err := cmd.MarkFlagRequired(flagCluster)
+	if err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+func setupFlags(cmd *cobra.Command) {
+	cmd.Flags().String(flagToken, "", "JWT Authentication token")
+	cmd.Flags().String(flagCluster, "", "Komodor cluster name to ping")
+	cmd.Flags().IntP(flagCount, "c", 5, "Number of messages to send, 0 means until interrupted")
+	cmd.Flags().DurationP(flagInterval, "i", time.Second, "Interval between messages")
+	cmd.Flags().Duration(flagTimeout, 5*time.Second, "Time to wait for each ack")
+	cmd.Flags().String(flagType, string(portforward.MTPing), "Message type to send: ping or keep-alive")
+	cmd.Flags().String(flagNamespace, "default", "Namespace for the port-forward resource")
+	cmd.Flags().String(flagResource, "", "Initialize port-forward session to this resource first, like pod/mypod")
+	cmd.Flags().Int(flagPort, 0, "Remote port for the port-forward session")
+	portforward.SetupDialFlags(cmd)
+}
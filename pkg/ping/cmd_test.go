@@ -0,0 +1,29 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/internal/hubtest"
+	"github.com/komodorio/komocli/pkg/portforward"
+)
+
+func TestPing(t *testing.T) {
+	hubtest.Start(t, hubtest.AckAll)
+
+	for _, msgType := range []portforward.MessageType{portforward.MTPing, portforward.MTKeepAlive} {
+		params := CmdParams{Cluster: "test", Count: 3, Interval: time.Millisecond, Timeout: time.Second, Type: msgType}
+		out := bytes.Buffer{}
+		err := params.Run(context.Background(), &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(out.String(), "3 messages sent, 3 acked, 0.0% loss") {
+			t.Errorf("unexpected output: %s", out.String())
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package portforward
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const HTTPSecretHeader = "X-Komocli-Secret"
+
+// clientRequestTimeout is how long local client has to send the request checked for the secret
+const clientRequestTimeout = 10 * time.Second
+
+// AccessPolicy decides which local clients are allowed to use the forwarded port
+type AccessPolicy struct {
+	Allow      []*net.IPNet
+	Deny       []*net.IPNet
+	MaxClients int
+	HTTPSecret string // when set, every connection has to start with HTTP request carrying the secret
+
+	active atomic.Int32
+}
+
+func NewAccessPolicy(allow []string, deny []string, maxClients int, httpSecret string) (*AccessPolicy, error) {
+	allowNets, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+
+	denyNets, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxClients < 0 {
+		return nil, fmt.Errorf("max clients can't be negative: %d", maxClients)
+	}
+
+	return &AccessPolicy{
+		Allow:      allowNets,
+		Deny:       denyNets,
+		MaxClients: maxClients,
+		HTTPSecret: httpSecret,
+	}, nil
+}
+
+// checkPeer validates client address against deny and allow lists, deny list takes precedence
+func (p *AccessPolicy) checkPeer(addr net.Addr) error {
+	ip := addrIP(addr)
+	if ip == nil {
+		return fmt.Errorf("can't determine IP of peer %s", addr)
+	}
+
+	for _, n := range p.Deny {
+		if n.Contains(ip) {
+			return fmt.Errorf("peer %s is in denied network %s", ip, n)
+		}
+	}
+
+	if len(p.Allow) == 0 {
+		return nil
+	}
+
+	for _, n := range p.Allow {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("peer %s is not in allowed networks", ip)
+}
+
+// acquire reserves a client slot, caller has to call release once connection is done
+func (p *AccessPolicy) acquire() error {
+	n := p.active.Add(1)
+	if p.MaxClients > 0 && int(n) > p.MaxClients {
+		p.active.Add(-1)
+		return fmt.Errorf("reached the limit of %d concurrent clients", p.MaxClients)
+	}
+	return nil
+}
+
+func (p *AccessPolicy) release() {
+	p.active.Add(-1)
+}
+
+// admit checks the peer address and reserves a client slot for it
+func (p *AccessPolicy) admit(addr net.Addr) error {
+	err := p.checkPeer(addr)
+	if err != nil {
+		return err
+	}
+	return p.acquire()
+}
+
+func (p *AccessPolicy) isRestricted() bool {
+	return len(p.Allow) > 0 || len(p.Deny) > 0 || p.HTTPSecret != ""
+}
+
+// authenticateHTTP reads the first HTTP request from conn and checks it for the shared secret.
+// Returned connection replays the request without the secret and with "Connection: close", so that
+// further requests on the connection can't skip the check. Upgraded connections, like WebSocket,
+// pass through once the upgrade request is authenticated.
+func (p *AccessPolicy) authenticateHTTP(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	if p.HTTPSecret == "" {
+		return conn, nil
+	}
+
+	err := conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HTTP request: %w", err)
+	}
+
+	if !p.isSecretValid(req) {
+		_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+		_, _ = io.WriteString(conn, "HTTP/1.1 401 Unauthorized\r\n"+
+			"WWW-Authenticate: Basic realm=\"komocli\"\r\n"+
+			"Content-Length: 0\r\n"+
+			"Connection: close\r\n\r\n")
+		return nil, errors.New("missing or wrong shared secret in HTTP request")
+	}
+
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	return newReplayConn(conn, br, p.stripSecret(req)), nil
+}
+
+func (p *AccessPolicy) isSecretValid(req *http.Request) bool {
+	provided := req.Header.Get(HTTPSecretHeader)
+	if provided == "" {
+		_, provided, _ = req.BasicAuth() // username is ignored
+	}
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(p.HTTPSecret)) == 1
+}
+
+// stripSecret removes the credential from the request, so that it does not reach the workload
+func (p *AccessPolicy) stripSecret(req *http.Request) *http.Request {
+	if req.Header.Get(HTTPSecretHeader) != "" {
+		req.Header.Del(HTTPSecretHeader)
+	} else {
+		req.Header.Del("Authorization")
+	}
+
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", "") // otherwise req.Write adds its own
+	}
+
+	if req.Header.Get("Upgrade") == "" {
+		req.Header.Del("Connection")
+		req.Header.Del("Keep-Alive")
+		req.Close = true
+	}
+	return req
+}
+
+// replayConn sends the authenticated request first. Then upgraded connections continue with the rest
+// of the client stream, while further data on other connections is dropped, as the pod closes them
+type replayConn struct {
+	net.Conn
+	head io.Reader
+	rest io.Reader
+}
+
+func newReplayConn(conn net.Conn, br *bufio.Reader, req *http.Request) *replayConn {
+	head, w := io.Pipe()
+	go func() {
+		_ = w.CloseWithError(req.Write(w)) // streams the body as the client sends it
+	}()
+
+	var rest io.Reader = br
+	if req.Close {
+		rest = discardReader{r: br}
+	}
+	return &replayConn{Conn: conn, head: head, rest: rest}
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	if c.head != nil {
+		n, err := c.head.Read(b)
+		if !errors.Is(err, io.EOF) {
+			return n, err
+		}
+
+		c.head = nil
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return c.rest.Read(b)
+}
+
+// discardReader drops the data until the error, like the end of the connection
+type discardReader struct {
+	r io.Reader
+}
+
+func (d discardReader) Read(b []byte) (int, error) {
+	for {
+		_, err := d.r.Read(b)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func parseCIDRs(items []string) ([]*net.IPNet, error) {
+	res := []*net.IPNet{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") { // single address
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", item)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case nil:
+		return nil
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return nil
+		}
+		return net.ParseIP(host)
+	}
+}
@@ -0,0 +1,155 @@
+package portforward
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessPolicyPeers(t *testing.T) {
+	policy, err := NewAccessPolicy([]string{"10.0.0.0/8", "192.168.1.5"}, []string{"10.1.0.0/16"}, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ip      string
+		allowed bool
+	}{
+		{ip: "10.0.0.1", allowed: true},
+		{ip: "10.1.2.3", allowed: false},
+		{ip: "192.168.1.5", allowed: true},
+		{ip: "192.168.1.6", allowed: false},
+		{ip: "127.0.0.1", allowed: false},
+	}
+
+	for _, c := range cases {
+		err := policy.checkPeer(&net.TCPAddr{IP: net.ParseIP(c.ip), Port: 1234})
+		if (err == nil) != c.allowed {
+			t.Errorf("wrong decision for %s: %v", c.ip, err)
+		}
+	}
+
+	_, err = NewAccessPolicy([]string{"not-an-ip"}, nil, 0, "")
+	if err == nil {
+		t.Errorf("invalid CIDR is expected to fail")
+	}
+}
+
+func TestAccessPolicyMaxClients(t *testing.T) {
+	policy, err := NewAccessPolicy(nil, nil, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}
+	if err := policy.admit(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := policy.admit(addr); err == nil {
+		t.Errorf("second client is expected to be refused")
+	}
+
+	policy.release()
+	if err := policy.admit(addr); err != nil {
+		t.Errorf("client is expected to be admitted after release: %s", err)
+	}
+}
+
+func TestAccessPolicyHTTPSecret(t *testing.T) {
+	policy, err := NewAccessPolicy(nil, nil, 0, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		req     string
+		allowed bool
+	}{
+		{req: "GET / HTTP/1.1\r\nHost: x\r\n\r\n", allowed: false},
+		{req: "GET / HTTP/1.1\r\nHost: x\r\nX-Komocli-Secret: wrong\r\n\r\n", allowed: false},
+		{req: "GET / HTTP/1.1\r\nHost: x\r\nX-Komocli-Secret: s3cret\r\n\r\n", allowed: true},
+		{req: "GET / HTTP/1.1\r\nHost: x\r\nAuthorization: Basic dXNlcjpzM2NyZXQ=\r\n\r\n", allowed: true},
+	}
+
+	for _, c := range cases {
+		client, server := net.Pipe()
+		go func() {
+			_, _ = io.WriteString(client, c.req)
+			_, _ = io.Copy(io.Discard, client) // drain the refusal response
+		}()
+
+		conn, err := policy.authenticateHTTP(server, time.Second)
+		if (err == nil) != c.allowed {
+			t.Errorf("wrong decision for request %q: %v", c.req, err)
+		}
+
+		if err == nil {
+			req, err := http.ReadRequest(bufio.NewReader(conn))
+			if err != nil || req.URL.Path != "/" || !req.Close || req.Header.Get(HTTPSecretHeader) != "" || req.Header.Get("Authorization") != "" {
+				t.Errorf("request is expected to be replayed without the secret and with Connection: close: %+v, %v", req, err)
+			}
+		}
+
+		_ = client.Close()
+		_ = server.Close()
+	}
+}
+
+func TestAccessPolicyHTTPSecretKeepAlive(t *testing.T) {
+	policy, err := NewAccessPolicy(nil, nil, 0, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		_, _ = io.WriteString(client, "POST /a HTTP/1.1\r\nHost: x\r\nX-Komocli-Secret: s3cret\r\nContent-Length: 4\r\n\r\nbody"+
+			"GET /b HTTP/1.1\r\nHost: x\r\n\r\n")
+		_ = client.Close()
+	}()
+
+	conn, err := policy.authenticateHTTP(server, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(string(replayed), "\r\n\r\nbody") || strings.Contains(string(replayed), "GET /b") || strings.Contains(string(replayed), "s3cret") {
+		t.Errorf("only the authenticated request is expected to reach the pod, got %q", replayed)
+	}
+}
+
+func TestAccessPolicyHTTPSecretUpgrade(t *testing.T) {
+	policy, err := NewAccessPolicy(nil, nil, 0, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		_, _ = io.WriteString(client, "GET /ws HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nX-Komocli-Secret: s3cret\r\n\r\nframes")
+		_ = client.Close()
+	}()
+
+	conn, err := policy.authenticateHTTP(server, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, _ := io.ReadAll(conn)
+	if !strings.Contains(string(replayed), "Upgrade: websocket") || !strings.HasSuffix(string(replayed), "\r\n\r\nframes") {
+		t.Errorf("upgraded connection is expected to pass through, got %q", replayed)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -17,10 +18,33 @@ import (
 
 const flagToken = "token"
 const flagTimeout = "timeout"
+const flagDialTimeout = "dial-timeout"
+const flagHandshakeTimeout = "handshake-timeout"
+const flagInitAckTimeout = "init-ack-timeout"
+const flagDataAckTimeout = "data-ack-timeout"
+const flagKeepAliveInterval = "keep-alive-interval"
+const flagPingInterval = "ping-interval"
+const flagDeadPeerTimeout = "dead-peer-timeout"
 const flagBrowser = "browser"
+const flagTUI = "tui"
 const flagAddress = "address"
 const flagNamespace = "namespace"
 const flagCluster = "cluster"
+const flagAllowCIDR = "allow-cidr"
+const flagDenyCIDR = "deny-cidr"
+const flagMaxClients = "max-clients"
+const flagHTTPSecret = "http-secret"
+const flagTLS = "tls"
+const flagTLSCert = "tls-cert"
+const flagTLSKey = "tls-key"
+const flagIdleTimeout = "idle-timeout"
+const flagMaxSessionDuration = "max-session-duration"
+const flagMaxForwardDuration = "max-forward-duration"
+const flagDrainTimeout = "drain-timeout"
+const flagDumpFrames = "dump-frames"
+const flagMetricsAddr = "metrics-addr"
+const flagEvents = "events"
+const flagEventsFD = "events-fd"
 
 var (
 	portforwardLong = templates.LongDesc(`
@@ -43,6 +67,18 @@ var (
 		# Listen on port 8888 on all addresses, forwarding to 5000 in the pod
 		komocli port-forward --address 0.0.0.0 pod/mypod 8888:5000 --namespace default --cluster my-cluster --token=...
 
+		# Listen on all addresses, but accept only clients from the office network
+		komocli port-forward --address 0.0.0.0 --allow-cidr 10.10.0.0/16 pod/mypod 8888:5000 --namespace default --cluster my-cluster --token=...
+
+		# Serve the local side over HTTPS with auto-generated self-signed certificate and open it in browser
+		komocli port-forward --tls --browser pod/mypod 8443:8080 --namespace default --cluster my-cluster --token=...
+
+		# Close database connections idle for 15 minutes, and stop forwarding after 8 hours
+		komocli port-forward --idle-timeout 15m --max-forward-duration 8h pod/mypod 5432 --namespace default --cluster my-cluster --token=...
+
+		# Watch active connections in a full-screen dashboard, with logs kept in a file
+		komocli port-forward --tui --log-file komocli.log pod/mypod 8888:5000 --namespace default --cluster my-cluster --token=...
+
 		# Listen on a random port locally, forwarding to 5000 in the pod
 		komocli port-forward pod/mypod :5000 --namespace default --cluster my-cluster --token=...`)
 )
@@ -51,12 +87,31 @@ type CmdParams struct {
 	Namespace    string
 	Token        string
 	Timeout      time.Duration
+	Timeouts     Timeouts
 	OpenBrowser  bool
+	TUI          bool
 	Address      string
 	Cluster      string
 	LocalPort    int
 	RemotePort   int
 	ResourceName string
+	AllowCIDRs   []string
+	DenyCIDRs    []string
+	MaxClients   int
+	HTTPSecret   string
+	TLS          bool
+	TLSCert      string
+	TLSKey       string
+	ConnLimits   SessionLimits
+	MaxDuration  time.Duration
+	DrainTimeout time.Duration
+	Dial         *DialOptions
+	DumpFile     string
+	MetricsAddr  string
+	Events       string
+	EventsFD     int
+
+	eventsFile *os.File // opened from EventsFD, closed by Run
 }
 
 func (p *CmdParams) AcceptArgs(cmd *cobra.Command, args []string) (err error) {
@@ -81,31 +136,221 @@ func (p *CmdParams) AcceptArgs(cmd *cobra.Command, args []string) (err error) {
 		p.Token = os.Getenv("KOMOCLI_JWT")
 	}
 
-	p.Timeout, err = flags.GetDuration(flagTimeout)
+	err = p.acceptTimeoutFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	p.Address, err = flags.GetString(flagAddress)
+	if err != nil {
+		return err
+	}
+
+	p.Namespace, err = flags.GetString(flagNamespace)
 	if err != nil {
 		return err
 	}
 
+	p.Cluster, err = flags.GetString(flagCluster)
+	if err != nil {
+		return err
+	}
+
+	err = p.acceptAccessFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	err = p.acceptLimitFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	p.Dial, err = AcceptDialFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	err = p.acceptDebugFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	err = p.acceptUIFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	return p.acceptTLSFlags(cmd)
+}
+
+func (p *CmdParams) acceptUIFlags(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
 	p.OpenBrowser, err = flags.GetBool(flagBrowser)
 	if err != nil {
 		return err
 	}
 
-	p.Address, err = flags.GetString(flagAddress)
+	p.TUI, err = flags.GetBool(flagTUI)
 	if err != nil {
 		return err
 	}
 
-	p.Namespace, err = flags.GetString(flagNamespace)
+	if p.TUI && p.Events != "" && p.EventsFD == int(os.Stdout.Fd()) {
+		return fmt.Errorf("--%s can't write into stdout together with --%s, set --%s", flagEvents, flagTUI, flagEventsFD)
+	}
+	return nil
+}
+
+func (p *CmdParams) acceptDebugFlags(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.DumpFile, err = flags.GetString(flagDumpFrames)
 	if err != nil {
 		return err
 	}
 
-	p.Cluster, err = flags.GetString(flagCluster)
+	p.MetricsAddr, err = flags.GetString(flagMetricsAddr)
+	if err != nil {
+		return err
+	}
+
+	p.Events, err = flags.GetString(flagEvents)
+	if err != nil {
+		return err
+	}
+
+	p.EventsFD, err = flags.GetInt(flagEventsFD)
 	if err != nil {
 		return err
 	}
 
+	if p.EventsFD < 0 {
+		return errors.New("events file descriptor can't be negative")
+	}
+
+	if p.Events != "" && p.Events != EventsFormatJSON {
+		return fmt.Errorf("unsupported events format %q, only %s is supported", p.Events, EventsFormatJSON)
+	}
+
+	if p.Events != "" {
+		p.eventsFile = os.NewFile(uintptr(p.EventsFD), "events")
+		_, err = p.eventsFile.Stat()
+		if err != nil {
+			return fmt.Errorf("invalid --%s %d: %w", flagEventsFD, p.EventsFD, err)
+		}
+	}
+	return nil
+}
+
+func (p *CmdParams) acceptAccessFlags(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.AllowCIDRs, err = flags.GetStringSlice(flagAllowCIDR)
+	if err != nil {
+		return err
+	}
+
+	p.DenyCIDRs, err = flags.GetStringSlice(flagDenyCIDR)
+	if err != nil {
+		return err
+	}
+
+	p.MaxClients, err = flags.GetInt(flagMaxClients)
+	if err != nil {
+		return err
+	}
+
+	p.HTTPSecret, err = flags.GetString(flagHTTPSecret)
+	if err != nil {
+		return err
+	}
+
+	if p.HTTPSecret == "" {
+		p.HTTPSecret = os.Getenv("KOMOCLI_HTTP_SECRET")
+	}
+
+	return nil
+}
+
+func (p *CmdParams) acceptTimeoutFlags(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.Timeout, err = flags.GetDuration(flagTimeout)
+	if err != nil {
+		return err
+	}
+
+	explicit := Timeouts{}
+	for flag, dst := range map[string]*time.Duration{
+		flagDialTimeout:       &explicit.Dial,
+		flagHandshakeTimeout:  &explicit.Handshake,
+		flagInitAckTimeout:    &explicit.InitAck,
+		flagDataAckTimeout:    &explicit.DataAck,
+		flagKeepAliveInterval: &explicit.KeepAlive,
+		flagPingInterval:      &explicit.Ping,
+		flagDeadPeerTimeout:   &explicit.DeadPeer,
+	} {
+		*dst, err = flags.GetDuration(flag)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.Timeouts = NewTimeouts(p.Timeout, explicit)
+	if !flags.Changed(flagDeadPeerTimeout) {
+		p.Timeouts.DeadPeer = defaultDeadPeer(p.Timeouts.KeepAlive)
+	}
+	return p.Timeouts.Validate()
+}
+
+func (p *CmdParams) acceptLimitFlags(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.ConnLimits.IdleTimeout, err = flags.GetDuration(flagIdleTimeout)
+	if err != nil {
+		return err
+	}
+
+	p.ConnLimits.MaxDuration, err = flags.GetDuration(flagMaxSessionDuration)
+	if err != nil {
+		return err
+	}
+
+	p.MaxDuration, err = flags.GetDuration(flagMaxForwardDuration)
+	if err != nil {
+		return err
+	}
+
+	p.DrainTimeout, err = flags.GetDuration(flagDrainTimeout)
+	if err != nil {
+		return err
+	}
+
+	if p.ConnLimits.IdleTimeout < 0 || p.ConnLimits.MaxDuration < 0 || p.MaxDuration < 0 || p.DrainTimeout < 0 {
+		return errors.New("session limits can't be negative")
+	}
+
+	return nil
+}
+
+func (p *CmdParams) acceptTLSFlags(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.TLS, err = flags.GetBool(flagTLS)
+	if err != nil {
+		return err
+	}
+
+	p.TLSCert, err = flags.GetString(flagTLSCert)
+	if err != nil {
+		return err
+	}
+
+	p.TLSKey, err = flags.GetString(flagTLSKey)
+	if err != nil {
+		return err
+	}
+
+	if !p.TLS && (p.TLSCert != "" || p.TLSKey != "") {
+		return fmt.Errorf("--%s and --%s require --%s", flagTLSCert, flagTLSKey, flagTLS)
+	}
+
 	return nil
 }
 
@@ -117,11 +362,62 @@ func (p *CmdParams) Run(ctx context.Context) (err error) {
 		RemotePort: p.RemotePort,
 	}
 
-	ctl := NewController(rSpec, p.Address, p.LocalPort, p.Token, p.Timeout)
+	access, err := NewAccessPolicy(p.AllowCIDRs, p.DenyCIDRs, p.MaxClients, p.HTTPSecret)
+	if err != nil {
+		return err
+	}
+
+	ctl := NewController(rSpec, p.Address, p.LocalPort, p.Token, p.Timeouts)
+	ctl.Access = access
+	ctl.ConnLimits = p.ConnLimits
+	ctl.MaxDuration = p.MaxDuration
+	ctl.DrainTimeout = p.DrainTimeout
+	ctl.Dial = p.Dial
+
+	if p.DumpFile != "" {
+		ctl.Dump, err = NewFrameDumper(p.DumpFile)
+		if err != nil {
+			return err
+		}
+		defer ctl.Dump.Close()
+		log.Infof("Recording all messages into %s", p.DumpFile)
+	}
+
+	if p.eventsFile != nil {
+		ctl.Events = NewEventSink(p.eventsFile)
+		if p.EventsFD != int(os.Stdout.Fd()) && p.EventsFD != int(os.Stderr.Fd()) {
+			defer p.eventsFile.Close() // stdout and stderr are still used after Run
+		}
+	}
+
+	if p.MetricsAddr != "" {
+		err = ServeMetrics(ctx, p.MetricsAddr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.TLS {
+		ctl.TLSConfig, err = NewListenerTLSConfig(p.Address, p.TLSCert, p.TLSKey)
+		if err != nil {
+			return err
+		}
+	}
 
 	afterInit := func(addr string) {}
 	if p.OpenBrowser {
-		afterInit = openBrowser
+		afterInit = func(addr string) {
+			openBrowser(localURL(addr, p.TLS))
+		}
+	}
+
+	if p.TUI {
+		var stopDashboard func()
+		ctx, stopDashboard, err = startDashboard(ctx, ctl)
+		if err != nil {
+			return err
+		}
+		defer stopDashboard()
 	}
 
 	err = ctl.Run(ctx, afterInit)
@@ -132,8 +428,21 @@ func (p *CmdParams) Run(ctx context.Context) (err error) {
 	return nil
 }
 
-func openBrowser(addr string) {
-	url := fmt.Sprintf("http://%s", addr) // https would not work well anyway
+// localURL is the URL to open the forward in browser, unspecified bind address is reached via localhost
+func localURL(addr string, isTLS bool) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			addr = net.JoinHostPort("localhost", port)
+		}
+	}
+
+	if isTLS {
+		return fmt.Sprintf("https://%s", addr)
+	}
+	return fmt.Sprintf("http://%s", addr)
+}
+
+func openBrowser(url string) {
 	log.Infof("Opening in browser: %s", url)
 	err := browser.OpenURL(url)
 	if err != nil {
@@ -169,12 +478,37 @@ func NewCommand() *cobra.Command {
 }
 
 func setupFlags(cmd *cobra.Command) {
-	cmd.Flags().Duration(flagTimeout, 5*time.Second, "Timeout for operations")
+	cmd.Flags().Duration(flagTimeout, 5*time.Second, "Timeout for operations, used for any of specific timeouts below that is not set")
+	cmd.Flags().Duration(flagDialTimeout, 0, "Timeout for connecting to Komodor WS backend")
+	cmd.Flags().Duration(flagHandshakeTimeout, 0, "Timeout for TLS and WebSocket handshake with Komodor WS backend")
+	cmd.Flags().Duration(flagInitAckTimeout, 0, "Timeout for session initialization to be acknowledged")
+	cmd.Flags().Duration(flagDataAckTimeout, 0, "Timeout for data and keep-alive messages to be acknowledged, raise it for slow links")
+	cmd.Flags().Duration(flagKeepAliveInterval, DefaultKeepAliveInterval, "Interval between keep-alive messages")
+	cmd.Flags().Duration(flagPingInterval, DefaultPingInterval, "Interval between latency measurements, 0 disables them")
+	cmd.Flags().Duration(flagDeadPeerTimeout, DefaultDeadPeerTimeout, "Close session when nothing was received from remote side for this long, 0 disables. "+
+		"Has to be longer than keep-alive interval, defaults to 6 of them if that is longer")
 	cmd.Flags().String(flagToken, "", "JWT Authentication token")
 	cmd.Flags().String(flagAddress, "localhost", "Network address to listen on (aka 'bind address')")
 	cmd.Flags().Bool(flagBrowser, false, "Open forwarded address automatically in browser")
+	cmd.Flags().Bool(flagTUI, false, "Show full-screen dashboard of active connections instead of log output, use --log-file to keep the logs")
 	cmd.Flags().String(flagNamespace, "default", "Namespace for the resource")
 	cmd.Flags().String(flagCluster, "", "Komodor cluster name that contains resource")
+	SetupDialFlags(cmd)
+	cmd.Flags().StringSlice(flagAllowCIDR, nil, "Accept connections only from these CIDRs or IPs (repeatable)")
+	cmd.Flags().StringSlice(flagDenyCIDR, nil, "Refuse connections from these CIDRs or IPs, takes precedence over allow list (repeatable)")
+	cmd.Flags().Int(flagMaxClients, 0, "Maximum number of concurrent client connections, 0 means unlimited")
+	cmd.Flags().Duration(flagIdleTimeout, 0, "Close forwarded connection after no data was transferred in either direction for this long, 0 disables")
+	cmd.Flags().Duration(flagMaxSessionDuration, 0, "Maximum lifetime of each forwarded connection, 0 means unlimited")
+	cmd.Flags().Duration(flagMaxForwardDuration, 0, "Stop the whole port-forward after this time, 0 means unlimited")
+	cmd.Flags().Duration(flagDrainTimeout, DefaultDrainTimeout, "On shutdown, wait this long for active connections to finish before closing them, 0 closes immediately")
+	cmd.Flags().Bool(flagTLS, false, "Serve the local side over TLS, with self-signed certificate unless --"+flagTLSCert+" and --"+flagTLSKey+" are given")
+	cmd.Flags().String(flagTLSCert, "", "PEM certificate file for --"+flagTLS)
+	cmd.Flags().String(flagTLSKey, "", "PEM private key file for --"+flagTLS)
+	cmd.Flags().String(flagHTTPSecret, "", "Require HTTP clients to present this shared secret via "+HTTPSecretHeader+" header or basic auth password")
+	cmd.Flags().String(flagEvents, "", "Write lifecycle events in this format, only 'json' is supported, for supervising tools")
+	cmd.Flags().Int(flagEventsFD, 1, "File descriptor to write --"+flagEvents+" into, stdout by default")
+	cmd.Flags().String(flagMetricsAddr, "", "Serve Prometheus metrics on this address, like localhost:9090")
+	cmd.Flags().String(flagDumpFrames, "", "Record every message sent and received into this JSON lines file, for 'komocli replay'. It contains forwarded data")
 }
 
 func validateFlags(cmd *cobra.Command) error {
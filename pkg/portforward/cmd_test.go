@@ -5,6 +5,7 @@ import (
 	"github.com/spf13/cobra"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestParams(t *testing.T) {
@@ -88,3 +89,57 @@ func TestNew(t *testing.T) {
 		t.Logf("We expect it to show help and return error: %v", err)
 	}
 }
+
+func TestTimeoutFlags(t *testing.T) {
+	cmd := &cobra.Command{}
+	setupFlags(cmd)
+	err := cmd.ParseFlags([]string{"--timeout=3s", "--data-ack-timeout=30s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := CmdParams{}
+	err = params.AcceptArgs(cmd, []string{"pod/x", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params.Timeouts.Dial != 3*time.Second || params.Timeouts.DataAck != 30*time.Second || params.Timeouts.KeepAlive != DefaultKeepAliveInterval {
+		t.Errorf("unexpected timeouts: %+v", params.Timeouts)
+	}
+
+	slow := &cobra.Command{}
+	setupFlags(slow)
+	_ = slow.ParseFlags([]string{"--keep-alive-interval=30s"})
+	err = params.AcceptArgs(slow, []string{"pod/x", "1"})
+	if err != nil || params.Timeouts.DeadPeer != 3*time.Minute {
+		t.Errorf("dead peer timeout is expected to follow long keep-alive interval: %s (%v)", params.Timeouts.DeadPeer, err)
+	}
+
+	err = cmd.ParseFlags([]string{"--keep-alive-interval=-1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = params.AcceptArgs(cmd, []string{"pod/x", "1"})
+	if err == nil {
+		t.Errorf("negative keep-alive interval is expected to fail validation")
+	}
+}
+
+func TestEventsFDFlag(t *testing.T) {
+	for fd, shouldFail := range map[string]bool{"1": false, "1000": true} {
+		cmd := &cobra.Command{}
+		setupFlags(cmd)
+		err := cmd.ParseFlags([]string{"--events=json", "--events-fd=" + fd})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		params := CmdParams{}
+		err = params.AcceptArgs(cmd, []string{"pod/x", "1"})
+		if (err != nil) != shouldFail {
+			t.Errorf("unexpected result for events file descriptor %s: %v", fd, err)
+		}
+	}
+}
@@ -2,70 +2,133 @@ package portforward
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const DefaultDrainTimeout = 10 * time.Second
+
 type Controller struct {
-	RemoteSpec RemoteSpec
-	Address    string
-	LocalPort  int
-	Token      string
-	timeout    time.Duration
+	RemoteSpec   RemoteSpec
+	Address      string
+	LocalPort    int
+	Token        string
+	Access       *AccessPolicy
+	TLSConfig    *tls.Config // when set, local side is served over TLS
+	ConnLimits   SessionLimits
+	MaxDuration  time.Duration // lifetime of the whole forward, 0 means unlimited
+	DrainTimeout time.Duration // how long to wait for active connections on shutdown
+	timeouts     Timeouts
+	Latency      LatencyStats // aggregated over all finished connections
+	Dial         *DialOptions
+	Dump         *FrameDumper // records messages of all connections when set
+	Events       *EventSink
+	active       *connRegistry
+	paused       atomic.Bool  // refuse new connections, keeping the listener open
+	listenAddr   atomic.Value // address actually listened on, known once listening
+	recent       recentErrors // shown in the dashboard
 }
 
 func (c *Controller) Run(ctx context.Context, afterInit func(addr string)) error {
 	// template message for session starts
-	initMsg := &SessionMessage{
-		MessageType: MTPortForwardInit,
-		Data: &WSPortForwardInitData{
-			Namespace: c.RemoteSpec.Namespace,
-			Resource:  c.RemoteSpec.PodName,
-			Port:      c.RemoteSpec.RemotePort,
-		},
-	}
+	initMsg := c.initMessage()
 
 	err := c.testConnection(ctx, initMsg)
 	if err != nil {
+		c.Events.Emit(EventPreflightFailed, EventFields{"cluster": c.RemoteSpec.AgentId, "error": err.Error()})
 		return err
 	}
-	log.Infof("Finished testing the connectivity, ready to accept connections")
+	c.Events.Emit(EventPreflightOK, EventFields{"cluster": c.RemoteSpec.AgentId})
+	c.logger().Infof("Finished testing the connectivity, ready to accept connections")
+
+	ctx, cancel := withForwardDeadline(ctx, c.MaxDuration)
+	defer cancel()
 
 	// check and bind local port, mind the host
 	listen, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.Address, c.LocalPort)) // TODO: what if we have random port?
 	if err != nil {
 		return err
 	}
-	log.Infof("Started listening for incoming connections: %s", listen.Addr())
+
+	if c.TLSConfig != nil {
+		listen = tls.NewListener(listen, c.TLSConfig)
+	}
+	c.listenAddr.Store(listen.Addr().String())
+	c.logger().Infof("Started listening for incoming connections: %s", listen.Addr())
+	if !isLoopbackAddr(listen.Addr()) && !c.Access.isRestricted() {
+		c.logger().Warnf("Listening on non-loopback address without access restrictions, anyone who can reach %s can use the forwarded port", listen.Addr())
+	}
+	c.Events.Emit(EventListening, EventFields{"address": listen.Addr().String()})
 	afterInit(listen.Addr().String())
 
 	go func() {
 		<-ctx.Done()
-		log.Debugf("Stopping to accept connections")
+		c.logger().Debugf("Stopping to accept connections")
 		listen.Close()
 	}()
 
 	// setup connection handler
 	c.acceptIncomingConns(ctx, listen, initMsg)
 
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.logger().Infof("Stopped: %s", context.Cause(ctx))
+	}
+
+	if c.Latency.Count() > 0 {
+		c.logger().Infof("Latency to %s: %s, min %s, max %s", c.RemoteSpec.AgentId, &c.Latency, c.Latency.Min(), c.Latency.Max())
+	}
+
+	reason := "stopped accepting connections"
+	if cause := context.Cause(ctx); cause != nil {
+		reason = cause.Error()
+	}
+	c.Events.Emit(EventShutdown, EventFields{"reason": reason})
+
 	// if not errored, shut down open conns gracefully
 	return nil
 }
 
+func (c *Controller) initMessage() *SessionMessage {
+	return &SessionMessage{
+		MessageType: MTPortForwardInit,
+		Data: &WSPortForwardInitData{
+			Namespace: c.RemoteSpec.Namespace,
+			Resource:  c.RemoteSpec.PodName,
+			Port:      c.RemoteSpec.RemotePort,
+		},
+	}
+}
+
+// TestConnection initializes trial port-forward session without forwarding any data
+func (c *Controller) TestConnection(ctx context.Context) error {
+	return c.testConnection(ctx, c.initMessage())
+}
+
 func (c *Controller) testConnection(ctx context.Context, initMsg *SessionMessage) error {
+	ctx, span := tracer.Start(ctx, "testConnection", trace.WithAttributes(sessionAttributes(c.RemoteSpec.AgentId, initMsg)...))
+	defer span.End()
+
 	// test connect to Komodor WS endpoint
-	ws := NewWSConnectionWrapper(ctx, nil, c.RemoteSpec.AgentId, c.Token, true, *initMsg, c.timeout)
+	ws := NewWSConnectionWrapper(ctx, nil, c.RemoteSpec.AgentId, c.Token, true, *initMsg, c.timeouts)
+	ws.Dial = c.Dial
+	ws.Dump = c.Dump
 	err := ws.Run()
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		komodorRBACSignature := "you are missing permissions to perform the following action"
 		if strings.Contains(err.Error(), komodorRBACSignature) {
-			log.Warnf("You have no RBAC permissions in Komodor to do port forwarding on this resource")
+			c.logger().Warnf("You have no RBAC permissions in Komodor to do port forwarding on this resource")
 		} else {
-			log.Warnf("Failed to test port-forward operability: %+v", err)
+			c.logger().Warnf("Failed to test port-forward operability: %+v", err)
 		}
 
 		return err
@@ -73,62 +136,125 @@ func (c *Controller) testConnection(ctx context.Context, initMsg *SessionMessage
 
 	err = ws.Stop()
 	if err != nil {
-		log.Warnf("Failed to send session termination message: %s", err)
+		c.logger().Warnf("Failed to send session termination message: %s", err)
 		return err
 	}
 	return err
 }
 
 func (c *Controller) acceptIncomingConns(ctx context.Context, listen net.Listener, initMsg *SessionMessage) {
+	connCtx, stopConns := detachedContext(ctx)
+	defer stopConns(nil)
+
 	wg := sync.WaitGroup{}
-	conns := []*WSConnectionWrapper{}
+	active := c.active
 	for {
 		conn, err := listen.Accept()
 		if err != nil {
 			if !isConnClosedErr(err) {
-				log.Warnf("Failed to accept incoming connection: %+v", err)
+				c.logger().Warnf("Failed to accept incoming connection: %+v", err)
+				c.recent.add("Failed to accept incoming connection: %s", err)
 			}
 			break
 		}
 
-		log.Infof("Accepted connection: %v", conn.LocalAddr())
-		ws := NewWSConnectionWrapper(ctx, conn, c.RemoteSpec.AgentId, c.Token, false, *initMsg, c.timeout)
-		conns = append(conns, ws)
+		peer := conn.RemoteAddr()
+		err = c.admit(peer)
+		if err != nil {
+			metricConnections.WithLabelValues("refused").Inc()
+			c.logger().WithField("peer", peer.String()).Warnf("Refused connection from %s: %s", peer, err)
+			c.recent.add("Refused connection from %s: %s", peer, err)
+			_ = conn.Close()
+			continue
+		}
+
+		c.logger().WithField("peer", peer.String()).Infof("Accepted connection from %s: %v", peer, conn.LocalAddr())
 
 		wg.Add(1)
 		go func() {
-			err := ws.Run()
+			defer wg.Done()
+			defer c.Access.release()
+
+			err := handshakeTLS(conn, clientRequestTimeout)
+			var authConn net.Conn
+			if err == nil {
+				authConn, err = c.Access.authenticateHTTP(conn, clientRequestTimeout)
+			}
 			if err != nil {
-				log.Warnf("Failed to run port-forwarding: %s", err)
+				metricConnections.WithLabelValues("refused").Inc()
+				c.logger().WithField("peer", peer.String()).Warnf("Refused connection from %s: %s", peer, err)
+				c.recent.add("Refused connection from %s: %s", peer, err)
+				_ = conn.Close()
+				return
+			}
+			metricConnections.WithLabelValues("accepted").Inc()
+			c.Events.Emit(EventConnectionAccepted, EventFields{"peer": peer.String()})
+			metricActiveSessions.Inc()
+			defer metricActiveSessions.Dec()
+
+			spanCtx, span := tracer.Start(connCtx, "forward connection", trace.WithAttributes(sessionAttributes(c.RemoteSpec.AgentId, initMsg)...))
+			span.SetAttributes(attrPeer.String(peer.String()))
+			defer span.End()
+
+			ws := NewWSConnectionWrapper(spanCtx, authConn, c.RemoteSpec.AgentId, c.Token, false, *initMsg, c.timeouts)
+			ws.limits = c.ConnLimits
+			ws.Dial = c.Dial
+			ws.Dump = c.Dump
+			ws.Events = c.Events
+			active.add(ws)
+			defer active.remove(ws)
+
+			err = ws.Run()
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				ws.logger().Warnf("Failed to run port-forwarding: %s", err)
+				c.recent.add("Connection from %s failed: %s", peer, err)
 			}
 
 			err = ws.Stop()
 			if err != nil {
-				log.Warnf("Failed to stop port-forwarding: %s", err)
+				ws.logger().Warnf("Failed to stop port-forwarding: %s", err)
 			}
-			wg.Done()
+			c.Latency.Merge(&ws.Latency)
+			c.Events.Emit(EventConnectionClosed, ws.eventFields(EventFields{"bytes_upstream": ws.bytesUp.Load(), "bytes_downstream": ws.bytesDown.Load()}))
 		}()
 	}
-	log.Infof("Stopped accepting incoming connections")
+	c.logger().Infof("Stopped accepting incoming connections")
 
-	for _, ws := range conns {
-		err := ws.Stop()
-		if err != nil {
-			log.Warnf("Failed to stop port-forwarding: %s", err)
-		}
-	}
+	c.drain(ctx, active, &wg)
 
+	stopConns(context.Cause(ctx)) // remaining connections get stopped with the reason of shutdown
 	wg.Wait()
 }
 
-func NewController(rSpec RemoteSpec, address string, lport int, jwt string, timeout time.Duration) *Controller {
+func NewController(rSpec RemoteSpec, address string, lport int, jwt string, timeouts Timeouts) *Controller {
 	return &Controller{
-		RemoteSpec: rSpec,
-		Address:    address,
-		LocalPort:  lport,
-		Token:      jwt,
-		timeout:    timeout,
+		RemoteSpec:   rSpec,
+		Address:      address,
+		LocalPort:    lport,
+		Token:        jwt,
+		Access:       &AccessPolicy{},
+		timeouts:     timeouts,
+		DrainTimeout: DefaultDrainTimeout,
+		active:       newConnRegistry(),
+	}
+}
+
+// admit checks whether a new connection from the peer can be served
+func (c *Controller) admit(peer net.Addr) error {
+	if c.paused.Load() {
+		return errors.New("accepting connections is paused")
 	}
+	return c.Access.admit(peer)
+}
+
+// logger adds the target of port-forward to log lines
+func (c *Controller) logger() *log.Entry {
+	return log.WithFields(log.Fields{
+		"cluster":   c.RemoteSpec.AgentId,
+		"namespace": c.RemoteSpec.Namespace,
+		"resource":  c.RemoteSpec.PodName,
+	})
 }
 
 type RemoteSpec struct {
@@ -137,3 +263,8 @@ type RemoteSpec struct {
 	PodName    string
 	RemotePort int
 }
+
+func isLoopbackAddr(addr net.Addr) bool {
+	ip := addrIP(addr)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,316 @@
+package portforward
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+const dashboardRefresh = time.Second
+
+const (
+	keyUp    = "up"
+	keyDown  = "down"
+	keyQuit  = "q"
+	keyClose = "x"
+	keyPause = "p"
+	keyCopy  = "c"
+)
+
+var errQuitDashboard = errors.New("stopped from dashboard")
+
+// Dashboard is a full-screen terminal view of the forwards and their connections
+type Dashboard struct {
+	in       *os.File
+	out      io.Writer
+	ctls     []*Controller
+	selected int // index in rows of the last render
+	rows     []dashboardRow
+	status   string // result of the last key press
+}
+
+// dashboardRow is a selectable line, either a listener or one of its connections
+type dashboardRow struct {
+	ctl  *Controller
+	conn *ConnStats
+}
+
+func NewDashboard(in *os.File, out io.Writer, ctls ...*Controller) *Dashboard {
+	return &Dashboard{in: in, out: out, ctls: ctls}
+}
+
+// Run shows the dashboard until the context is done or user quits, quitting stops the forwards via quit
+func (d *Dashboard) Run(ctx context.Context, quit func()) error {
+	fd := int(d.in.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to switch terminal into raw mode: %w", err)
+	}
+	defer term.Restore(fd, state)
+
+	// log lines would mess up the screen
+	logOut := log.StandardLogger().Out
+	if logOut == os.Stderr {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(logOut)
+	}
+
+	_, _ = io.WriteString(d.out, "\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	defer io.WriteString(d.out, "\x1b[?25h\x1b[?1049l")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // lets readKeys go once its pending read returns
+
+	keys := make(chan string, 16)
+	go d.readKeys(ctx, keys)
+
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	for {
+		d.draw()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case key := <-keys:
+			if key == keyQuit {
+				quit()
+				return nil
+			}
+			d.handleKey(key)
+		}
+	}
+}
+
+// readKeys returns after the context is done and the next read finishes, reading the terminal can't be interrupted.
+// That read takes one keystroke typed after the dashboard is gone, which is acceptable as the dashboard only
+// stops together with the forwards, right before komocli exits
+func (d *Dashboard) readKeys(ctx context.Context, keys chan<- string) {
+	buf := make([]byte, 64)
+	for {
+		n, err := d.in.Read(buf)
+		if err != nil || ctx.Err() != nil {
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			select {
+			case keys <- key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// parseKeys translates raw terminal input into known keys, ignoring the rest
+func parseKeys(input []byte) []string {
+	var res []string
+	for len(input) > 0 {
+		switch {
+		case bytes.HasPrefix(input, []byte("\x1b[A")):
+			res = append(res, keyUp)
+			input = input[3:]
+			continue
+		case bytes.HasPrefix(input, []byte("\x1b[B")):
+			res = append(res, keyDown)
+			input = input[3:]
+			continue
+		}
+
+		switch input[0] {
+		case 'k':
+			res = append(res, keyUp)
+		case 'j':
+			res = append(res, keyDown)
+		case 'q', 0x03: // Ctrl+C does not raise a signal in raw mode
+			res = append(res, keyQuit)
+		case 'x', 'p', 'c':
+			res = append(res, string(input[0]))
+		}
+		input = input[1:]
+	}
+	return res
+}
+
+func (d *Dashboard) handleKey(key string) {
+	if len(d.rows) == 0 {
+		return
+	}
+
+	row := d.rows[d.selected]
+	switch key {
+	case keyUp:
+		d.selected = max(d.selected-1, 0)
+	case keyDown:
+		d.selected = min(d.selected+1, len(d.rows)-1)
+	case keyPause:
+		paused := !row.ctl.paused.Load()
+		row.ctl.SetPaused(paused)
+		d.status = "Resumed accepting connections"
+		if paused {
+			d.status = "Paused accepting connections"
+		}
+	case keyClose:
+		d.closeConn(row)
+	case keyCopy:
+		d.copyURL(row)
+	}
+}
+
+func (d *Dashboard) closeConn(row dashboardRow) {
+	if row.conn == nil {
+		d.status = "Select a connection to close"
+		return
+	}
+
+	if row.ctl.CloseConn(row.conn.Peer, errors.New("closed from dashboard")) {
+		d.status = "Closed connection from " + row.conn.Peer
+	} else {
+		d.status = "Connection from " + row.conn.Peer + " is already closed"
+	}
+}
+
+// copyURL puts the local URL into clipboard with OSC 52 sequence, which most terminal emulators support
+func (d *Dashboard) copyURL(row dashboardRow) {
+	url := row.ctl.Stats().URL
+	if url == "" {
+		d.status = "Not listening yet"
+		return
+	}
+
+	_, _ = fmt.Fprintf(d.out, "\x1b]52;c;%s\x07", base64.StdEncoding.EncodeToString([]byte(url)))
+	d.status = "Copied " + url + " to clipboard"
+}
+
+func (d *Dashboard) draw() {
+	width, _, err := term.GetSize(int(d.in.Fd()))
+	if err != nil {
+		width = 120
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString("\x1b[H\x1b[2J") // home, clear screen
+	for _, line := range d.render() {
+		buf.WriteString(fitWidth(line, width))
+		buf.WriteString("\r\n") // raw mode does not return carriage
+	}
+	_, _ = d.out.Write(buf.Bytes())
+}
+
+// render builds the lines of the screen and refreshes selectable rows
+func (d *Dashboard) render() []string {
+	lines := []string{"komocli dashboard   ↑/↓ select   x close connection   p pause/resume accepting   c copy URL   q quit", ""}
+	d.rows = d.rows[:0]
+	for _, ctl := range d.ctls {
+		stats := ctl.Stats()
+		d.rows = append(d.rows, dashboardRow{ctl: ctl})
+		lines = append(lines, d.cursor()+listenerLine(stats))
+		lines = append(lines, fmt.Sprintf("    %-22s %-36s %8s %10s %10s %8s", "PEER", "SESSION", "AGE", "IN", "OUT", "ACK RTT"))
+		for i := range stats.Conns {
+			conn := stats.Conns[i]
+			d.rows = append(d.rows, dashboardRow{ctl: ctl, conn: &conn})
+			lines = append(lines, d.cursor()+connLine(conn))
+		}
+
+		for _, msg := range stats.RecentErrors {
+			lines = append(lines, "  ! "+msg)
+		}
+		lines = append(lines, "")
+	}
+	d.selected = min(d.selected, max(len(d.rows)-1, 0))
+	return append(lines, d.status)
+}
+
+// cursor marks the row being rendered if it is selected
+func (d *Dashboard) cursor() string {
+	if len(d.rows)-1 == d.selected {
+		return "> "
+	}
+	return "  "
+}
+
+func listenerLine(s ListenerStats) string {
+	local, state := s.URL, "accepting"
+	switch {
+	case s.Address == "":
+		local, state = "(not listening yet)", "testing connection"
+	case s.Paused:
+		state = "paused"
+	}
+
+	line := fmt.Sprintf("%s -> %s %s/%s:%d [%s]", local, s.Remote.AgentId, s.Remote.Namespace, s.Remote.PodName, s.Remote.RemotePort, state)
+	if s.Latency > 0 {
+		line += fmt.Sprintf(" avg latency %s", s.Latency.Round(time.Millisecond))
+	}
+	return line
+}
+
+func connLine(c ConnStats) string {
+	rtt := "-"
+	if c.AckRTT > 0 {
+		rtt = c.AckRTT.Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("  %-22s %-36s %8s %10s %10s %8s", c.Peer, c.SessionId, c.Age.Round(time.Second), FormatBytes(c.BytesDown), FormatBytes(c.BytesUp), rtt)
+}
+
+// FormatBytes makes byte count readable, like 1.5 MiB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// fitWidth cuts the line so it does not wrap
+func fitWidth(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) <= width {
+		return line
+	}
+	return string(runes[:width])
+}
+
+// startDashboard shows the dashboard in background. Quitting it counts as stop signal when the caller has set up
+// WithStopRequest, so that the next signal forces draining to stop, and cancels returned context otherwise
+func startDashboard(ctx context.Context, ctls ...*Controller) (context.Context, func(), error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil, nil, errors.New("dashboard requires an interactive terminal")
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	quit := stopRequest(ctx)
+	if quit == nil {
+		quit = func() { cancel(errQuitDashboard) }
+	}
+
+	dash := NewDashboard(os.Stdin, os.Stdout, ctls...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := dash.Run(ctx, quit)
+		if err != nil {
+			log.Warnf("Failed to show dashboard: %s", err)
+		}
+	}()
+
+	stop := func() {
+		cancel(nil)
+		<-done
+	}
+	return ctx, stop, nil
+}
@@ -0,0 +1,100 @@
+package portforward
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys := parseKeys([]byte("\x1b[Ajx\x1b[Bz\x03"))
+	expected := []string{keyUp, keyDown, keyClose, keyDown, keyQuit}
+	if strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+func TestReadKeysStops(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		(&Dashboard{in: r}).readKeys(ctx, make(chan string)) // nobody receives the keys
+		close(done)
+	}()
+
+	_, _ = w.WriteString("jjj")
+	cancel()
+	_, _ = w.WriteString("k")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("reading keys is expected to stop once the dashboard is gone")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, expected := range map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 5 << 30: "5.0 GiB"} {
+		if res := FormatBytes(n); res != expected {
+			t.Errorf("%d: expected %s, got %s", n, expected, res)
+		}
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	ctl := NewController(RemoteSpec{AgentId: "test", Namespace: "default", PodName: "pod/x", RemotePort: 80}, "127.0.0.1", 0, "token", NewTimeouts(time.Second, Timeouts{}))
+	ctl.listenAddr.Store("127.0.0.1:8080")
+
+	conn, other := net.Pipe()
+	defer other.Close()
+	ws := NewWSConnectionWrapper(context.Background(), conn, "test", "", false, *ctl.initMessage(), ctl.timeouts)
+	ws.sessionIdForLogs.Store("sess-1")
+	ws.bytesUp.Add(2048)
+	ctl.active.add(ws)
+	ctl.recent.add("Refused connection from %s: %s", "10.0.0.1:1234", "denied")
+
+	out := bytes.Buffer{}
+	dash := NewDashboard(nil, &out, ctl)
+	screen := strings.Join(dash.render(), "\n")
+	for _, expected := range []string{"> http://127.0.0.1:8080 -> test default/pod/x:80 [accepting]", "sess-1", "2.0 KiB", "Refused connection from 10.0.0.1:1234: denied"} {
+		if !strings.Contains(screen, expected) {
+			t.Errorf("expected %q on screen:\n%s", expected, screen)
+		}
+	}
+
+	dash.handleKey(keyCopy)
+	if !strings.Contains(out.String(), base64.StdEncoding.EncodeToString([]byte("http://127.0.0.1:8080"))) {
+		t.Errorf("URL is not copied: %q", out.String())
+	}
+
+	dash.handleKey(keyPause)
+	if err := ctl.admit(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}); err == nil {
+		t.Errorf("paused forward is not supposed to admit connections")
+	}
+	if screen := strings.Join(dash.render(), "\n"); !strings.Contains(screen, "[paused]") {
+		t.Errorf("expected paused listener:\n%s", screen)
+	}
+
+	dash.handleKey(keyClose)
+	if ws.ctx.Err() != nil {
+		t.Errorf("listener row is selected, no connection is supposed to close")
+	}
+
+	dash.handleKey(keyDown)
+	dash.handleKey(keyClose)
+	if !strings.Contains(ws.exitMessage(), "closed from dashboard") {
+		t.Errorf("expected selected connection to close, got: %s", ws.exitMessage())
+	}
+}
@@ -0,0 +1,84 @@
+package portforward
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultWSAddress = "wss://app.komodor.com"
+
+// WSBaseURL returns the base URL of Komodor WS backend, it can be overridden via KOMOCLI_WS_URL
+func WSBaseURL() string {
+	base := os.Getenv("KOMOCLI_WS_URL")
+	if base == "" {
+		base = DefaultWSAddress
+	}
+	return base
+}
+
+// baseURL is the base URL of Komodor WS backend to connect to with these options
+func (o *DialOptions) baseURL() string {
+	if o.BaseURL != "" {
+		return o.BaseURL
+	}
+	return WSBaseURL()
+}
+
+// wsClientEndpoint builds URL and headers to connect to the agent as a client
+func wsClientEndpoint(base string, agentId string, jwt string) (string, http.Header) {
+	hdr := http.Header{}
+	url := fmt.Sprintf("%s/ws/client/%s", base, agentId)
+
+	if os.Getenv("KOMOCLI_DEV") == "" {
+		c := http.Cookie{Name: "JWT_TOKEN", Value: jwt}
+		hdr.Set("Cookie", c.String())
+	} else {
+		url += "?authorization=" + jwt
+	}
+	return url, hdr
+}
+
+// DialWS opens WebSocket connection to Komodor backend on behalf of the client
+func DialWS(ctx context.Context, agentId string, jwt string, timeouts Timeouts, opts *DialOptions) (*websocket.Conn, *http.Response, error) {
+	if opts == nil {
+		opts = &DialOptions{}
+	}
+
+	wsURL, hdr := wsClientEndpoint(opts.baseURL(), agentId, jwt)
+	parsed, err := url.Parse(wsURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	proxy, err := opts.ProxyFor(parsed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig, err := opts.TLSConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dialer := &websocket.Dialer{
+		HandshakeTimeout: timeouts.Handshake,
+		TLSClientConfig:  tlsConfig,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return opts.DialTCP(ctx, proxy, addr, timeouts.Dial)
+		},
+	}
+
+	if proxy != nil {
+		log.Infof("Connecting to WS backend at %s via proxy %s", wsURL, proxy.Redacted())
+	} else {
+		log.Infof("Connecting to WS backend at %s", wsURL)
+	}
+	return dialer.DialContext(ctx, wsURL, hdr)
+}
@@ -0,0 +1,255 @@
+package portforward
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const flagCAFile = "ca-file"
+const flagClientCert = "client-cert"
+const flagClientKey = "client-key"
+const flagProxy = "proxy"
+const flagTLSServerName = "tls-server-name"
+const flagInsecureSkipTLSVerify = "insecure-skip-tls-verify"
+const flagTransport = "transport"
+
+// DialOptions customize how connection to Komodor WS backend is established
+type DialOptions struct {
+	CAFile             string // PEM bundle trusted in addition to system roots
+	ClientCertFile     string
+	ClientKeyFile      string
+	Proxy              string // overrides proxy from environment, NO_PROXY still applies
+	TLSServerName      string
+	InsecureSkipVerify bool
+	Transport          string // one of TransportAuto, TransportWebSocket, TransportHTTP
+	BaseURL            string // overrides WSBaseURL, for local stand-ins of Komodor backend
+
+	once      sync.Once
+	tlsConfig *tls.Config
+	tlsErr    error
+}
+
+func SetupDialFlags(cmd *cobra.Command) {
+	cmd.Flags().String(flagCAFile, "", "PEM file with CA certificates to trust when connecting to Komodor, in addition to system ones")
+	cmd.Flags().String(flagClientCert, "", "PEM client certificate file for mutual TLS with Komodor")
+	cmd.Flags().String(flagClientKey, "", "PEM client key file for mutual TLS with Komodor")
+	cmd.Flags().String(flagProxy, "", "Proxy URL to connect to Komodor through, overrides HTTPS_PROXY, NO_PROXY still applies")
+	cmd.Flags().String(flagTLSServerName, "", "Server name to verify Komodor certificate against, if it differs from the host")
+	cmd.Flags().Bool(flagInsecureSkipTLSVerify, false, "Do not verify Komodor certificate. DANGEROUS, use only for troubleshooting")
+	cmd.Flags().String(flagTransport, TransportWebSocket, "Transport to Komodor: websocket, or http and auto to fall back to http when WebSocket upgrade is blocked. "+
+		"HTTP transport is EXPERIMENTAL and works only with Komodor endpoints serving it")
+}
+
+func AcceptDialFlags(cmd *cobra.Command) (opts *DialOptions, err error) {
+	flags := cmd.Flags()
+	opts = &DialOptions{}
+	opts.CAFile, err = flags.GetString(flagCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.ClientCertFile, err = flags.GetString(flagClientCert)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.ClientKeyFile, err = flags.GetString(flagClientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Proxy, err = flags.GetString(flagProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.TLSServerName, err = flags.GetString(flagTLSServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.InsecureSkipVerify, err = flags.GetBool(flagInsecureSkipTLSVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Transport, err = flags.GetString(flagTransport)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.Transport {
+	case TransportAuto, TransportWebSocket, TransportHTTP:
+	default:
+		return nil, fmt.Errorf("unsupported transport %q, use %s, %s or %s", opts.Transport, TransportAuto, TransportWebSocket, TransportHTTP)
+	}
+
+	if opts.Proxy != "" {
+		_, err = url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+	}
+
+	_, err = opts.TLSConfig() // fail early on bad files
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.InsecureSkipVerify {
+		log.Warnf("!!! TLS certificate verification is DISABLED with --%s, the connection to Komodor is NOT protected from interception !!!", flagInsecureSkipTLSVerify)
+	}
+
+	return opts, nil
+}
+
+// TLSConfig builds client TLS config for WS backend, files are loaded once
+func (o *DialOptions) TLSConfig() (*tls.Config, error) {
+	o.once.Do(func() {
+		o.tlsConfig, o.tlsErr = o.buildTLSConfig()
+	})
+	return o.tlsConfig, o.tlsErr
+}
+
+func (o *DialOptions) buildTLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         o.TLSServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify, // explicitly requested by user, with a warning
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if o.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.ClientCertFile != "" || o.ClientKeyFile != "" {
+		if o.ClientCertFile == "" || o.ClientKeyFile == "" {
+			return nil, errors.New("both client certificate and key files have to be specified")
+		}
+
+		cert, err := tls.LoadX509KeyPair(o.ClientCertFile, o.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// ProxyFor selects proxy for the WS URL, returns nil if connection has to be direct
+func (o *DialOptions) ProxyFor(target *url.URL) (*url.URL, error) {
+	httpURL := *target
+	httpURL.Scheme = strings.Replace(target.Scheme, "ws", "http", 1)
+
+	cfg := httpproxy.FromEnvironment()
+	if o.Proxy != "" {
+		cfg.HTTPProxy = o.Proxy
+		cfg.HTTPSProxy = o.Proxy
+	}
+	return cfg.ProxyFunc()(&httpURL)
+}
+
+// DialTCP connects to addr directly or tunnels through the proxy with CONNECT method
+func (o *DialOptions) DialTCP(ctx context.Context, proxy *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	if proxy == nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", HostPortOf(proxy))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy: %w", err)
+	}
+
+	if proxy.Scheme == "https" {
+		cfg, err := o.TLSConfig()
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		cfg = cfg.Clone()
+		cfg.ServerName = proxy.Hostname()
+		cfg.Certificates = nil // client certificate is meant for Komodor, not for the proxy
+		conn = tls.Client(conn, cfg)
+	}
+
+	err = connectThroughProxy(conn, proxy, addr, timeout)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func connectThroughProxy(conn net.Conn, proxy *url.URL, addr string, timeout time.Duration) error {
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+	if proxy.User != nil {
+		pwd, _ := proxy.User.Password()
+		creds := &http.Request{Header: http.Header{}}
+		creds.SetBasicAuth(proxy.User.Username(), pwd)
+		req.Header.Set("Proxy-Authorization", creds.Header.Get("Authorization"))
+	}
+
+	err := req.Write(conn)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy refused CONNECT with status %s", resp.Status)
+	}
+	return nil
+}
+
+// HostPortOf returns host:port of the URL, with default port for the scheme
+func HostPortOf(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" || u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
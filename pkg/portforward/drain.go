@@ -0,0 +1,111 @@
+package portforward
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// connRegistry keeps track of forwarded connections that are still running
+type connRegistry struct {
+	mx    sync.Mutex
+	conns map[*WSConnectionWrapper]struct{}
+}
+
+func newConnRegistry() *connRegistry {
+	return &connRegistry{conns: map[*WSConnectionWrapper]struct{}{}}
+}
+
+func (r *connRegistry) add(ws *WSConnectionWrapper) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.conns[ws] = struct{}{}
+}
+
+func (r *connRegistry) remove(ws *WSConnectionWrapper) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	delete(r.conns, ws)
+}
+
+func (r *connRegistry) list() []*WSConnectionWrapper {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	res := make([]*WSConnectionWrapper, 0, len(r.conns))
+	for ws := range r.conns {
+		res = append(res, ws)
+	}
+	return res
+}
+
+type forceStopKey struct{}
+
+// WithForceStop gives the channel that cuts draining short when closed, like on repeated stop signal
+func WithForceStop(ctx context.Context, force <-chan struct{}) context.Context {
+	return context.WithValue(ctx, forceStopKey{}, force)
+}
+
+// forceStop is nil, blocking forever, unless the caller has set it up
+func forceStop(ctx context.Context) <-chan struct{} {
+	force, _ := ctx.Value(forceStopKey{}).(<-chan struct{})
+	return force
+}
+
+type stopRequestKey struct{}
+
+// WithStopRequest gives the function that stops komocli the same way stop signal does, so that the next signal forces it
+func WithStopRequest(ctx context.Context, stop func()) context.Context {
+	return context.WithValue(ctx, stopRequestKey{}, stop)
+}
+
+// stopRequest is nil unless the caller has set it up
+func stopRequest(ctx context.Context) func() {
+	stop, _ := ctx.Value(stopRequestKey{}).(func())
+	return stop
+}
+
+// drain lets in-flight connections finish on their own, until timeout expires or the stop is forced
+func (c *Controller) drain(ctx context.Context, active *connRegistry, wg *sync.WaitGroup) {
+	open := active.list()
+	if c.DrainTimeout <= 0 || len(open) == 0 {
+		return
+	}
+
+	c.logger().Infof("Waiting up to %s for %d active connection(s) to finish, repeat the signal to stop immediately", c.DrainTimeout, len(open))
+	logOpenConns(open)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(c.DrainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		c.logger().Infof("All connections finished")
+		return
+	case <-timer.C:
+		c.logger().Warnf("Drain timeout exceeded, closing remaining connections")
+	case <-forceStop(ctx):
+		c.logger().Warnf("Forced to stop, closing remaining connections")
+	}
+	logOpenConns(active.list())
+}
+
+func logOpenConns(conns []*WSConnectionWrapper) {
+	for _, ws := range conns {
+		stats := ws.stats(time.Now()) // SessionId may still be getting written
+		log.Infof("Still open: %s (session %s, age %s)", stats.Peer, stats.SessionId, stats.Age.Round(time.Second))
+	}
+}
+
+// detachedContext creates context for connections, that is not cancelled together with parent, to allow draining
+func detachedContext(parent context.Context) (context.Context, context.CancelCauseFunc) {
+	return context.WithCancelCause(context.WithoutCancel(parent))
+}
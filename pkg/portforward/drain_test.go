@@ -0,0 +1,31 @@
+package portforward
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDrainForceStop(t *testing.T) {
+	conn, other := net.Pipe()
+	defer other.Close()
+
+	c := NewController(RemoteSpec{}, "localhost", 0, "", NewTimeouts(time.Second, Timeouts{}))
+	c.DrainTimeout = time.Hour
+	c.active.add(NewWSConnectionWrapper(context.Background(), conn, "", "", false, SessionMessage{}, c.timeouts))
+
+	wg := sync.WaitGroup{}
+	wg.Add(1) // the connection never finishes on its own
+	defer wg.Done()
+
+	force := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(force) })
+
+	started := time.Now()
+	c.drain(WithForceStop(context.Background(), force), c.active, &wg)
+	if time.Since(started) > time.Second {
+		t.Errorf("drain is expected to stop when forced")
+	}
+}
@@ -0,0 +1,105 @@
+package portforward
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/logging"
+)
+
+type FrameDirection string
+
+const (
+	FrameSent     FrameDirection = "sent"     // from the client to ws-hub
+	FrameReceived FrameDirection = "received" // from ws-hub to the client
+)
+
+// PreflightConn is the number of the preflight check connection in the dump, forwarded connections are numbered from 1
+const PreflightConn = 0
+
+// DumpedFrame is one line of frame dump file
+type DumpedFrame struct {
+	Conn      int             `json:"conn"`                // number of the connection within the dump
+	Preflight bool            `json:"preflight,omitempty"` // frame of the preflight check, not of a forwarded connection
+	ElapsedNs int64           `json:"elapsedNs"`           // monotonic time since the dump was started
+	Direction FrameDirection  `json:"direction"`
+	Frame     json.RawMessage `json:"frame"`
+}
+
+// FrameDumper records every message sent and received by WS connections into JSON lines file
+type FrameDumper struct {
+	started time.Time
+	conns   atomic.Int32
+
+	mx  sync.Mutex
+	out *os.File
+	enc *json.Encoder
+}
+
+func NewFrameDumper(path string) (*FrameDumper, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open frame dump file: %w", err)
+	}
+
+	return &FrameDumper{started: time.Now(), out: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (d *FrameDumper) nextConn() int {
+	return int(d.conns.Add(1))
+}
+
+func (d *FrameDumper) record(conn int, dir FrameDirection, frame []byte) {
+	if d == nil {
+		return
+	}
+
+	rec := DumpedFrame{
+		Conn:      conn,
+		Preflight: conn == PreflightConn,
+		ElapsedNs: time.Since(d.started).Nanoseconds(),
+		Direction: dir,
+		Frame:     json.RawMessage(logging.Redact(string(frame))),
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	_ = d.enc.Encode(&rec) // dump is best effort, it should not break the session
+}
+
+func (d *FrameDumper) Close() error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.out.Close()
+}
+
+// ReadFrameDump loads frames recorded by FrameDumper
+func ReadFrameDump(path string) ([]DumpedFrame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	frames := []DumpedFrame{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024) // data frames can be large
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		frame := DumpedFrame{}
+		err := json.Unmarshal(scanner.Bytes(), &frame)
+		if err != nil {
+			return nil, fmt.Errorf("malformed frame at line %d: %w", line, err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, scanner.Err()
+}
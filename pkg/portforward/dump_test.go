@@ -0,0 +1,42 @@
+package portforward
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFrameDump(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frames.jsonl")
+	dump, err := NewFrameDumper(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dump.record(PreflightConn, FrameSent, []byte(`{"messageType":"port_forward_init","data":{}}`))
+	conn := dump.nextConn()
+	dump.record(conn, FrameSent, []byte(`{"messageType":"keep-alive","data":{}}`))
+	dump.record(conn, FrameReceived, []byte(`{"messageType":"error","data":{"errorMessage":"bad url ?authorization=abc"}}`))
+	err = dump.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := ReadFrameDump(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames) != 3 || !frames[0].Preflight || frames[1].Preflight {
+		t.Fatalf("only the preflight check is expected to be tagged: %+v", frames)
+	}
+
+	frames = frames[1:]
+	if frames[0].Direction != FrameSent || frames[1].Direction != FrameReceived || frames[1].Conn != 1 {
+		t.Fatalf("unexpected frames: %+v", frames)
+	}
+
+	if strings.Contains(string(frames[1].Frame), "abc") || frames[1].ElapsedNs < frames[0].ElapsedNs {
+		t.Errorf("token is expected to be redacted and time to be monotonic: %s", frames[1].Frame)
+	}
+}
@@ -0,0 +1,53 @@
+package portforward
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+const EventsFormatJSON = "json"
+
+const (
+	EventPreflightOK        = "preflight_ok"
+	EventPreflightFailed    = "preflight_failed"
+	EventListening          = "listening"
+	EventConnectionAccepted = "connection_accepted"
+	EventSessionInitialized = "session_initialized"
+	EventAckTimeout         = "ack_timeout"
+	EventRemoteError        = "remote_error"
+	EventConnectionClosed   = "connection_closed"
+	EventShutdown           = "shutdown"
+)
+
+// EventFields carry event-specific data
+type EventFields map[string]interface{}
+
+// EventSink writes lifecycle events as JSON lines, for tools supervising komocli
+type EventSink struct {
+	mx  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewEventSink(out io.Writer) *EventSink {
+	return &EventSink{enc: json.NewEncoder(out)}
+}
+
+// Emit writes the event, it is safe to call on nil sink
+func (s *EventSink) Emit(event string, fields EventFields) {
+	if s == nil {
+		return
+	}
+
+	rec := EventFields{}
+	for k, v := range fields {
+		rec[k] = v
+	}
+	rec["event"] = event
+	rec["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	_ = s.enc.Encode(rec) // supervisor going away should not break forwarding
+}
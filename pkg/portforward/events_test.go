@@ -0,0 +1,66 @@
+package portforward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/internal/hubtest"
+)
+
+func TestEvents(t *testing.T) {
+	hubtest.Start(t, hubtest.AckAll)
+
+	out := bytes.Buffer{}
+	ctl := NewController(RemoteSpec{AgentId: "test", Namespace: "default", PodName: "pod/x", RemotePort: 1}, "127.0.0.1", 0, "token", NewTimeouts(time.Second, Timeouts{}))
+	ctl.Events = NewEventSink(&out)
+	ctl.DrainTimeout = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := ctl.Run(ctx, func(addr string) {
+		go func() {
+			defer cancel()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = conn.Write([]byte("hello"))
+			time.Sleep(100 * time.Millisecond) // let the data get acked
+			_ = conn.Close()
+			time.Sleep(100 * time.Millisecond)
+		}()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []string{}
+	var closed map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		ev := map[string]interface{}{}
+		err := json.Unmarshal([]byte(line), &ev)
+		if err != nil {
+			t.Fatalf("malformed event %q: %s", line, err)
+		}
+		events = append(events, ev["event"].(string))
+		if ev["event"] == EventConnectionClosed {
+			closed = ev
+		}
+	}
+
+	expected := []string{EventPreflightOK, EventListening, EventConnectionAccepted, EventSessionInitialized, EventConnectionClosed, EventShutdown}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected events: %v", events)
+	}
+
+	if closed == nil || closed["bytes_upstream"] != float64(5) || closed["session_id"] != hubtest.SessionId {
+		t.Errorf("unexpected connection_closed event: %v", closed)
+	}
+}
@@ -0,0 +1,110 @@
+package portforward
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SessionLimits restrict how long a forwarded connection may live
+type SessionLimits struct {
+	IdleTimeout time.Duration // measured from the last payload in either direction
+	MaxDuration time.Duration
+}
+
+func (l SessionLimits) isSet() bool {
+	return l.IdleTimeout > 0 || l.MaxDuration > 0
+}
+
+func (l SessionLimits) checkInterval() time.Duration {
+	interval := time.Second
+	for _, d := range []time.Duration{l.IdleTimeout, l.MaxDuration} {
+		if d > 0 {
+			interval = min(interval, d/10)
+		}
+	}
+	return interval
+}
+
+// expiryWarningLead tells how long before expiry the user gets warned
+func expiryWarningLead(d time.Duration) time.Duration {
+	return min(d/5, time.Minute)
+}
+
+func (ws *WSConnectionWrapper) touch() {
+	ws.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (ws *WSConnectionWrapper) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, ws.lastActivity.Load()))
+}
+
+// watchLimits cancels session context once idle timeout or max duration is exceeded
+func (ws *WSConnectionWrapper) watchLimits() {
+	if ws.isConnTest || !ws.limits.isSet() {
+		return
+	}
+
+	warnedIdle := false
+	warnedLifetime := false
+
+	ticker := time.NewTicker(ws.limits.checkInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ws.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if ws.limits.MaxDuration > 0 {
+				left := ws.limits.MaxDuration - now.Sub(ws.started)
+				if left <= 0 {
+					ws.cancel(fmt.Errorf("session exceeded maximum duration of %s", ws.limits.MaxDuration))
+					return
+				}
+
+				if !warnedLifetime && left <= expiryWarningLead(ws.limits.MaxDuration) {
+					ws.logger().Warnf("Connection %s will be closed in %s due to maximum session duration", ws.peer(), left.Round(time.Second))
+					warnedLifetime = true
+				}
+			}
+
+			if ws.limits.IdleTimeout > 0 {
+				left := ws.limits.IdleTimeout - ws.idleFor(now)
+				if left <= 0 {
+					ws.cancel(fmt.Errorf("session was idle for %s", ws.limits.IdleTimeout))
+					return
+				}
+
+				if left > expiryWarningLead(ws.limits.IdleTimeout) {
+					warnedIdle = false // there was activity since the warning
+				} else if !warnedIdle {
+					ws.logger().Warnf("Connection %s will be closed in %s due to inactivity", ws.peer(), left.Round(time.Second))
+					warnedIdle = true
+				}
+			}
+		}
+	}
+}
+
+// withForwardDeadline limits the lifetime of the whole forward, warning the user before it expires
+func withForwardDeadline(ctx context.Context, maxDuration time.Duration) (context.Context, context.CancelFunc) {
+	if maxDuration <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	cause := fmt.Errorf("port-forward exceeded maximum duration of %s", maxDuration)
+	ctx, cancel := context.WithTimeoutCause(ctx, maxDuration, cause)
+
+	lead := expiryWarningLead(maxDuration)
+	warn := time.AfterFunc(maxDuration-lead, func() {
+		log.Warnf("Port-forward will be stopped in %s due to maximum duration", lead.Round(time.Second))
+	})
+
+	return ctx, func() {
+		warn.Stop()
+		cancel()
+	}
+}
@@ -0,0 +1,40 @@
+package portforward
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchLimits(t *testing.T) {
+	cases := []struct {
+		limits SessionLimits
+		reason string
+	}{
+		{limits: SessionLimits{IdleTimeout: 50 * time.Millisecond}, reason: "idle"},
+		{limits: SessionLimits{MaxDuration: 50 * time.Millisecond, IdleTimeout: time.Minute}, reason: "maximum duration"},
+	}
+
+	for _, c := range cases {
+		conn, other := net.Pipe()
+		ws := NewWSConnectionWrapper(context.Background(), conn, "", "", false, SessionMessage{}, NewTimeouts(time.Second, Timeouts{}))
+		ws.limits = c.limits
+
+		go ws.watchLimits()
+
+		select {
+		case <-ws.ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("session is expected to expire: %v", c.limits)
+		}
+
+		if msg := ws.exitMessage(); !strings.Contains(msg, c.reason) {
+			t.Errorf("unexpected exit message: %s", msg)
+		}
+
+		_ = conn.Close()
+		_ = other.Close()
+	}
+}
@@ -0,0 +1,143 @@
+package portforward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// There is no reconnects metric: connections to Komodor are never re-established, each forwarded connection
+// dials its own and ends when it is lost, which shows up in connections_total and remote_errors_total instead
+const metricsNamespace = "komocli"
+
+// remoteErrorClasses maps substrings of error messages from Komodor to the classes they are counted under,
+// messages become label values only this way to keep cardinality fixed. The first match wins
+var remoteErrorClasses = []struct {
+	substr string
+	class  string
+}{
+	{"missing permissions", "permission"},
+	{"forbidden", "permission"},
+	{"unauthorized", "permission"},
+	{"not found", "not_found"},
+	{"timeout", "timeout"},
+	{"timed out", "timeout"},
+	{"deadline exceeded", "timeout"},
+	{"connection refused", "connection"},
+	{"connection reset", "connection"},
+	{"broken pipe", "connection"},
+	{"agent", "agent"},
+}
+
+const remoteErrorOther = "other"
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	metricActiveSessions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "active_sessions",
+		Help:      "Number of forwarded connections currently served",
+	})
+	metricConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "connections_total",
+		Help:      "Incoming local connections by result, accepted or refused",
+	}, []string{"result"})
+	metricBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "bytes_total",
+		Help:      "Forwarded data by direction, upstream is towards the pod",
+	}, []string{"direction"})
+	metricAckLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "ack_latency_seconds",
+		Help:      "Time between sending a message and receiving its ack",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12), // 5ms to ~10s
+	})
+	metricAckTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ack_timeouts_total",
+		Help:      "Messages that were not acknowledged in time",
+	})
+	metricTransportFallbacks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "transport_fallbacks_total",
+		Help:      "Connections to Komodor that fell back to HTTP transport after WebSocket upgrade was blocked",
+	})
+	metricRemoteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "remote_errors_total",
+		Help:      "Error messages received from Komodor, by class: permission, not_found, timeout, connection, agent or other",
+	}, []string{"class"})
+	metricKeepAliveFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "keep_alive_failures_total",
+		Help:      "Keep-alive messages that failed to be sent",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		metricActiveSessions,
+		metricConnections,
+		metricBytes,
+		metricAckLatency,
+		metricAckTimeouts,
+		metricTransportFallbacks,
+		metricRemoteErrors,
+		metricKeepAliveFailures,
+	)
+}
+
+func countRemoteError(msg string) {
+	metricRemoteErrors.WithLabelValues(remoteErrorClass(msg)).Inc()
+}
+
+func remoteErrorClass(msg string) string {
+	msg = strings.ToLower(msg)
+	for _, c := range remoteErrorClasses {
+		if strings.Contains(msg, c.substr) {
+			return c.class
+		}
+	}
+	return remoteErrorOther
+}
+
+// ServeMetrics starts exposing Prometheus metrics on /metrics in background, until the context is done
+func ServeMetrics(ctx context.Context, addr string) error {
+	listen, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	go func() {
+		err := srv.Serve(listen)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Warnf("Failed to serve metrics: %s", err)
+		}
+	}()
+
+	log.Infof("Serving metrics on http://%s/metrics", listen.Addr())
+	return nil
+}
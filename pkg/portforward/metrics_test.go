@@ -0,0 +1,52 @@
+package portforward
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestMetrics(t *testing.T) {
+	countRemoteError(`pods "web-1" not found`)
+	metricBytes.WithLabelValues("upstream").Add(10)
+
+	srv := httptest.NewServer(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	bts, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{`komocli_bytes_total{direction="upstream"}`, `komocli_remote_errors_total{class="not_found"}`, "komocli_active_sessions"} {
+		if !strings.Contains(string(bts), expected) {
+			t.Errorf("metric %s is missing in the output", expected)
+		}
+	}
+}
+
+func TestRemoteErrorClass(t *testing.T) {
+	cases := map[string]string{
+		"you are missing permissions to perform the following action": "permission",
+		`pods "web-1" not found`:                              "not_found",
+		"dial tcp 10.0.0.1:8080: connect: connection refused": "connection",
+		"Agent is not connected":                              "agent",
+		"Failed to decode Base64: illegal data at 4":          remoteErrorOther,
+	}
+
+	for msg, expected := range cases {
+		if class := remoteErrorClass(msg); class != expected {
+			t.Errorf("%q: expected class %s, got %s", msg, expected, class)
+		}
+	}
+}
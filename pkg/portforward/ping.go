@@ -0,0 +1,300 @@
+package portforward
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// LatencyStats accumulates round-trip time measurements
+type LatencyStats struct {
+	mx    sync.Mutex
+	count int
+	last  time.Duration
+	min   time.Duration
+	max   time.Duration
+	sum   time.Duration
+	sumSq float64 // in squared seconds, for jitter
+}
+
+func (s *LatencyStats) Add(rtt time.Duration) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.count == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+	s.count++
+	s.last = rtt
+	s.sum += rtt
+	s.sumSq += rtt.Seconds() * rtt.Seconds()
+}
+
+// Merge adds measurements from other stats, the last value is taken from other
+func (s *LatencyStats) Merge(other *LatencyStats) {
+	other.mx.Lock()
+	o := LatencyStats{count: other.count, last: other.last, min: other.min, max: other.max, sum: other.sum, sumSq: other.sumSq}
+	other.mx.Unlock()
+
+	if o.count == 0 {
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	s.last = o.last
+	s.sum += o.sum
+	s.sumSq += o.sumSq
+}
+
+func (s *LatencyStats) Count() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.count
+}
+
+func (s *LatencyStats) Last() time.Duration {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.last
+}
+
+func (s *LatencyStats) Min() time.Duration {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.min
+}
+
+func (s *LatencyStats) Max() time.Duration {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.max
+}
+
+func (s *LatencyStats) Avg() time.Duration {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / time.Duration(s.count)
+}
+
+// Jitter is the standard deviation of measurements, same as 'mdev' of ping(8)
+func (s *LatencyStats) Jitter() time.Duration {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.count == 0 {
+		return 0
+	}
+
+	avg := s.sum.Seconds() / float64(s.count)
+	variance := s.sumSq/float64(s.count) - avg*avg
+	return time.Duration(math.Sqrt(math.Max(variance, 0)) * float64(time.Second))
+}
+
+func (s *LatencyStats) String() string {
+	if s.Count() == 0 {
+		return "no measurements"
+	}
+	return fmt.Sprintf("last %s, avg %s over %d pings", s.Last().Round(time.Microsecond), s.Avg().Round(time.Microsecond), s.Count())
+}
+
+// loopPing periodically sends ping messages to measure round-trip time
+func (ws *WSConnectionWrapper) loopPing() {
+	if ws.isConnTest || ws.timeouts.Ping <= 0 {
+		return
+	}
+
+	select {
+	case <-ws.chReady: // session has to be initialized first
+	case <-ws.ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(ws.timeouts.Ping)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ws.ctx.Done():
+			return
+		case now := <-ticker.C:
+			ws.expireProbes(now)
+		}
+
+		err := ws.sendPing()
+		if err != nil {
+			ws.logger().Debugf("Failed to send ping: %s", err)
+			return
+		}
+	}
+}
+
+func (ws *WSConnectionWrapper) sendPing() error {
+	return ws.sendProbe(ws.newProbe(MTPing)) // older backends might not answer pings, dead peer detection covers that
+}
+
+func (ws *WSConnectionWrapper) newProbe(t MessageType) *SessionMessage {
+	if t == MTKeepAlive {
+		return ws.newSessMessage(t, &WSKeepaliveData{})
+	}
+	return ws.newSessMessage(t, &WSPingData{})
+}
+
+// sendProbe sends message which round-trip time gets measured once it is acked
+func (ws *WSConnectionWrapper) sendProbe(msg *SessionMessage) error {
+	ws.pendingPings.Set(msg.MessageId, time.Now())
+	err := ws.sendWS(msg, false) // not using regular ack expectation, because lost probe must not stop the session
+	if err != nil {
+		ws.pendingPings.Remove(msg.MessageId)
+	}
+	return err
+}
+
+// expireProbes forgets probes that were not acked in time, older backends don't answer pings at all
+func (ws *WSConnectionWrapper) expireProbes(now time.Time) {
+	expiry := ws.timeouts.DeadPeer
+	if expiry <= 0 {
+		expiry = ws.timeouts.DataAck
+	}
+
+	for id, sent := range ws.pendingPings.Items() {
+		if now.Sub(sent) > expiry {
+			ws.pendingPings.Remove(id)
+		}
+	}
+}
+
+// handlePingAck records round-trip time, returns false if the ack is not for a ping
+func (ws *WSConnectionWrapper) handlePingAck(acked string) bool {
+	sent, ok := ws.pendingPings.Pop(acked)
+	if !ok {
+		return false
+	}
+
+	rtt := time.Since(sent)
+	ws.Latency.Add(rtt)
+	ws.logger().Debugf("Ping round-trip to %s: %s (avg %s)", ws.agentId, ws.Latency.Last(), ws.Latency.Avg())
+
+	if waiter, found := ws.probeWaiters.Pop(acked); found {
+		waiter <- rtt
+	}
+	return true
+}
+
+// respondPing acknowledges ping received from the remote side
+func (ws *WSConnectionWrapper) respondPing(msg *SessionMessage) {
+	err := ws.sendWS(ws.newSessMessage(MTAck, &WSAckData{AckedMessageID: msg.MessageId}), false)
+	if err != nil {
+		ws.logger().Debugf("Failed to respond to ping: %s", err)
+	}
+}
+
+// watchPeer declares the remote side dead if nothing was received from it for too long
+func (ws *WSConnectionWrapper) watchPeer() {
+	if ws.isConnTest || ws.timeouts.DeadPeer <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(min(time.Second, ws.timeouts.DeadPeer/10))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ws.ctx.Done():
+			return
+		case now := <-ticker.C:
+			silent := now.Sub(time.Unix(0, ws.lastReceived.Load()))
+			if silent > ws.timeouts.DeadPeer {
+				ws.logger().Warnf("Nothing received from remote side for %s, considering it dead", silent.Round(time.Millisecond))
+				ws.cancel(fmt.Errorf("remote side did not respond for %s", ws.timeouts.DeadPeer))
+				return
+			}
+		}
+	}
+}
+
+// Open connects to WS backend and starts handling incoming messages, without bridging any data.
+// It is meant for wrappers created without TCP connection. If initialize is set, the session gets initialized first.
+func (ws *WSConnectionWrapper) Open(initialize bool) error {
+	ws.probing = true
+
+	var err error
+	ws.transport, err = ws.connectWS()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			err := ws.readWS()
+			if err != nil {
+				ws.cancel(err)
+				return
+			}
+		}
+	}()
+
+	if !initialize {
+		return nil
+	}
+
+	err = ws.init()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ws.chReady:
+		return nil
+	case <-ws.ctx.Done():
+		return context.Cause(ws.ctx)
+	}
+}
+
+// Err returns the reason of session end, or nil if it is still running
+func (ws *WSConnectionWrapper) Err() error {
+	if ws.ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(ws.ctx)
+}
+
+// Probe sends single message of given type and waits for its ack, returning the round-trip time
+func (ws *WSConnectionWrapper) Probe(t MessageType, timeout time.Duration) (time.Duration, error) {
+	msg := ws.newProbe(t)
+	waiter := make(chan time.Duration, 1)
+	ws.probeWaiters.Set(msg.MessageId, waiter)
+	defer ws.probeWaiters.Remove(msg.MessageId)
+
+	err := ws.sendProbe(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case rtt := <-waiter:
+		return rtt, nil
+	case <-timer.C:
+		ws.pendingPings.Remove(msg.MessageId)
+		return 0, fmt.Errorf("no ack within %s", timeout)
+	case <-ws.ctx.Done():
+		return 0, context.Cause(ws.ctx)
+	}
+}
@@ -0,0 +1,46 @@
+package portforward
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLatencyStats(t *testing.T) {
+	stats := LatencyStats{}
+	if stats.Avg() != 0 || stats.Jitter() != 0 {
+		t.Errorf("empty stats are expected to be zero")
+	}
+
+	for _, ms := range []int{10, 20, 30} {
+		stats.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	if stats.Min() != 10*time.Millisecond || stats.Max() != 30*time.Millisecond || stats.Avg() != 20*time.Millisecond {
+		t.Errorf("unexpected stats: %s", &stats)
+	}
+
+	jitter := stats.Jitter()
+	if jitter < 8*time.Millisecond || jitter > 9*time.Millisecond { // sqrt(200/3) ~= 8.16ms
+		t.Errorf("unexpected jitter: %s", jitter)
+	}
+
+	total := LatencyStats{}
+	total.Merge(&stats)
+	total.Merge(&LatencyStats{})
+	if total.Count() != 3 || total.Avg() != stats.Avg() {
+		t.Errorf("unexpected merged stats: %s", &total)
+	}
+}
+
+func TestExpireProbes(t *testing.T) {
+	ws := NewWSConnectionWrapper(context.Background(), nil, "", "", true, SessionMessage{}, NewTimeouts(time.Second, Timeouts{DeadPeer: time.Minute}))
+	now := time.Now()
+	ws.pendingPings.Set("old", now.Add(-2*time.Minute))
+	ws.pendingPings.Set("recent", now.Add(-time.Second))
+
+	ws.expireProbes(now)
+	if ws.pendingPings.Has("old") || !ws.pendingPings.Has("recent") {
+		t.Errorf("only unanswered probes older than dead peer timeout are expected to expire: %v", ws.pendingPings.Keys())
+	}
+}
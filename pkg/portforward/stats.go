@@ -0,0 +1,118 @@
+package portforward
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxRecentErrors = 5
+
+// recentErrors keeps the last few problems of the forward, for showing them to user
+type recentErrors struct {
+	mx   sync.Mutex
+	list []string
+}
+
+func (e *recentErrors) add(format string, args ...interface{}) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	msg := fmt.Sprintf("%s %s", time.Now().Format(time.TimeOnly), fmt.Sprintf(format, args...))
+	e.list = append(e.list, msg)
+	if len(e.list) > maxRecentErrors {
+		e.list = e.list[len(e.list)-maxRecentErrors:]
+	}
+}
+
+func (e *recentErrors) get() []string {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	return append([]string(nil), e.list...)
+}
+
+// ConnStats is a snapshot of a forwarded connection
+type ConnStats struct {
+	Peer      string
+	SessionId string
+	Age       time.Duration
+	BytesUp   int64         // sent towards the pod
+	BytesDown int64         // received from the pod
+	AckRTT    time.Duration // of the latest acknowledged message, 0 if none yet
+}
+
+// ListenerStats is a snapshot of the forward and its active connections
+type ListenerStats struct {
+	Remote       RemoteSpec
+	Address      string // empty until listening
+	URL          string
+	Paused       bool
+	Conns        []ConnStats // oldest first
+	RecentErrors []string
+	Latency      time.Duration // average over finished connections
+}
+
+// Stats collects current state of the forward
+func (c *Controller) Stats() ListenerStats {
+	res := ListenerStats{
+		Remote:       c.RemoteSpec,
+		Paused:       c.paused.Load(),
+		RecentErrors: c.recent.get(),
+		Latency:      c.Latency.Avg(),
+	}
+
+	if addr, ok := c.listenAddr.Load().(string); ok {
+		res.Address = addr
+		res.URL = localURL(addr, c.TLSConfig != nil)
+	}
+
+	now := time.Now()
+	for _, ws := range c.active.list() {
+		res.Conns = append(res.Conns, ws.stats(now))
+	}
+	sort.Slice(res.Conns, func(i, j int) bool {
+		return res.Conns[i].Age > res.Conns[j].Age
+	})
+	return res
+}
+
+// SetPaused stops or resumes accepting new connections, the active ones keep working
+func (c *Controller) SetPaused(paused bool) {
+	c.paused.Store(paused)
+	if paused {
+		c.logger().Infof("Paused accepting new connections")
+	} else {
+		c.logger().Infof("Resumed accepting new connections")
+	}
+}
+
+// CloseConn stops forwarding the connection from the peer, returns false if there is no such connection
+func (c *Controller) CloseConn(peer string, reason error) bool {
+	for _, ws := range c.active.list() {
+		if ws.peer() == peer {
+			ws.cancel(reason)
+			return true
+		}
+	}
+	return false
+}
+
+// Stats of the session so far, SessionId is empty until it is initialized
+func (ws *WSConnectionWrapper) Stats() ConnStats {
+	return ws.stats(time.Now())
+}
+
+func (ws *WSConnectionWrapper) stats(now time.Time) ConnStats {
+	res := ConnStats{
+		Peer:      ws.peer(),
+		Age:       now.Sub(ws.started),
+		BytesUp:   ws.bytesUp.Load(),
+		BytesDown: ws.bytesDown.Load(),
+		AckRTT:    time.Duration(ws.lastAckRTT.Load()),
+	}
+	if id, ok := ws.sessionIdForLogs.Load().(string); ok {
+		res.SessionId = id
+	}
+	return res
+}
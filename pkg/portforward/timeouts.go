@@ -0,0 +1,85 @@
+package portforward
+
+import (
+	"fmt"
+	"time"
+)
+
+const DefaultKeepAliveInterval = 5 * time.Second
+const DefaultPingInterval = 15 * time.Second
+const DefaultDeadPeerTimeout = 30 * time.Second
+
+// deadPeerKeepAlives is how many keep-alive intervals the default dead peer timeout spans at least
+const deadPeerKeepAlives = 6
+
+// defaultDeadPeer keeps the default dead peer timeout longer than keep-alive interval, whatever it is
+func defaultDeadPeer(keepAlive time.Duration) time.Duration {
+	return max(DefaultDeadPeerTimeout, deadPeerKeepAlives*keepAlive)
+}
+
+// Timeouts holds the timing settings of WS session
+type Timeouts struct {
+	Dial      time.Duration // establishing TCP connection to WS backend
+	Handshake time.Duration // TLS and WebSocket upgrade
+	InitAck   time.Duration // waiting for session init message to be acknowledged
+	DataAck   time.Duration // waiting for data and keep-alive messages to be acknowledged
+	KeepAlive time.Duration // interval between keep-alive messages
+	Ping      time.Duration // interval between latency measurements, 0 disables them
+	DeadPeer  time.Duration // remote side is considered dead after not sending anything for this long, 0 disables
+}
+
+// NewTimeouts uses the base timeout for every setting that is not specified explicitly
+func NewTimeouts(base time.Duration, explicit Timeouts) Timeouts {
+	res := explicit
+	for _, d := range []*time.Duration{&res.Dial, &res.Handshake, &res.InitAck, &res.DataAck} {
+		if *d == 0 {
+			*d = base
+		}
+	}
+
+	if res.KeepAlive == 0 {
+		res.KeepAlive = DefaultKeepAliveInterval
+	}
+	return res
+}
+
+func (t Timeouts) Validate() error {
+	named := []struct {
+		name string
+		val  time.Duration
+	}{
+		{"dial", t.Dial},
+		{"handshake", t.Handshake},
+		{"init ack", t.InitAck},
+		{"data ack", t.DataAck},
+		{"keep-alive", t.KeepAlive},
+	}
+
+	for _, n := range named {
+		if n.val <= 0 {
+			return fmt.Errorf("%s timeout has to be positive, got %s", n.name, n.val)
+		}
+	}
+
+	if t.Ping < 0 || t.DeadPeer < 0 {
+		return fmt.Errorf("ping interval and dead peer timeout can't be negative")
+	}
+
+	if t.DeadPeer > 0 && t.DeadPeer <= t.KeepAlive {
+		return fmt.Errorf("dead peer timeout (%s) has to be longer than keep-alive interval (%s)", t.DeadPeer, t.KeepAlive)
+	}
+
+	if t.KeepAlive < 100*time.Millisecond {
+		return fmt.Errorf("keep-alive interval is too small: %s", t.KeepAlive)
+	}
+
+	return nil
+}
+
+// ackTimeout picks the timeout to wait for acknowledgement of the message
+func (ws *WSConnectionWrapper) ackTimeout(msg *SessionMessage) time.Duration {
+	if msg == ws.initMsg {
+		return ws.timeouts.InitAck
+	}
+	return ws.timeouts.DataAck
+}
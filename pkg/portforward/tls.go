@@ -0,0 +1,113 @@
+package portforward
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NewListenerTLSConfig builds server-side TLS config for the local listener.
+// If cert and key files are not provided, a self-signed certificate is generated for the bind address.
+func NewListenerTLSConfig(bindAddress string, certFile string, keyFile string) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("both TLS certificate and key files have to be specified")
+		}
+
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+	} else {
+		cert, err = generateSelfSignedCert(certHosts(bindAddress))
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+		}
+		log.Infof("Generated self-signed certificate, SHA256 fingerprint: %x", sha256.Sum256(cert.Certificate[0]))
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// certHosts lists the names of the listener for its certificate. Unspecified addresses like 0.0.0.0 are not
+// names to connect to, clients use localhost or the address of the machine then
+func certHosts(bindAddress string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if ip := net.ParseIP(bindAddress); bindAddress == "" || ip != nil && ip.IsUnspecified() || slices.Contains(hosts, bindAddress) {
+		return hosts
+	}
+	return append(hosts, bindAddress)
+}
+
+// handshakeTLS completes the handshake of TLS connection within the timeout, so that clients failing it
+// or sending nothing don't hold a client slot and don't open Komodor sessions. Plain connections pass as is
+func handshakeTLS(conn net.Conn, timeout time.Duration) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	return nil
+}
+
+func generateSelfSignedCert(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	tpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"komocli"}, CommonName: hosts[0]},
+		NotBefore:             time.Now().Add(-time.Hour), // tolerate small clock skew
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else {
+			tpl.DNSNames = append(tpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,216 @@
+package portforward
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/komodorio/komocli/pkg/internal/hubtest"
+)
+
+func TestCertHosts(t *testing.T) {
+	for bind, extra := range map[string]string{"": "", "0.0.0.0": "", "::": "", "127.0.0.1": "", "localhost": "", "10.0.0.5": "10.0.0.5", "dev.local": "dev.local"} {
+		hosts := certHosts(bind)
+		if !slices.Contains(hosts, "localhost") || !slices.Contains(hosts, "127.0.0.1") {
+			t.Errorf("%q: loopback names are expected, got %v", bind, hosts)
+		}
+
+		if extra == "" && len(hosts) != 3 || extra != "" && !slices.Contains(hosts, extra) {
+			t.Errorf("%q: unexpected hosts %v", bind, hosts)
+		}
+	}
+}
+
+func TestLocalURL(t *testing.T) {
+	cases := map[string]string{
+		"0.0.0.0:8443":   "https://localhost:8443",
+		"[::]:8443":      "https://localhost:8443",
+		"10.0.0.5:8443":  "https://10.0.0.5:8443",
+		"127.0.0.1:8443": "https://127.0.0.1:8443",
+	}
+
+	for addr, expected := range cases {
+		if url := localURL(addr, true); url != expected {
+			t.Errorf("%s: expected %s, got %s", addr, expected, url)
+		}
+	}
+
+	if url := localURL("127.0.0.1:80", false); url != "http://127.0.0.1:80" {
+		t.Errorf("unexpected plain URL: %s", url)
+	}
+}
+
+// trusting returns client config that trusts only the certificate of the server config
+func trusting(t *testing.T, cfg *tls.Config, serverName string) *tls.Config {
+	cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{RootCAs: pool, ServerName: serverName}
+}
+
+// handshakeWith serves one TLS connection with the config, and dials it as the client
+func handshakeWith(t *testing.T, server *tls.Config, client *tls.Config) error {
+	listen, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listen.Close()
+
+	go func() {
+		conn, err := listen.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = handshakeTLS(conn, time.Second)
+		_, _ = conn.Write([]byte("ok"))
+	}()
+
+	conn, err := tls.Dial("tcp", listen.Addr().String(), client)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	bts, err := io.ReadAll(conn)
+	if err == nil && string(bts) != "ok" {
+		t.Errorf("unexpected data over TLS: %q", bts)
+	}
+	return err
+}
+
+func TestListenerTLSConfig(t *testing.T) {
+	cfg, err := NewListenerTLSConfig("0.0.0.0", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"localhost", "127.0.0.1"} {
+		if err := handshakeWith(t, cfg, trusting(t, cfg, name)); err != nil {
+			t.Errorf("handshake for %s failed: %s", name, err)
+		}
+	}
+
+	if err := handshakeWith(t, cfg, trusting(t, cfg, "0.0.0.0")); err == nil {
+		t.Errorf("unspecified address is not supposed to be in the certificate")
+	}
+
+	if err := handshakeWith(t, cfg, &tls.Config{ServerName: "localhost"}); err == nil {
+		t.Errorf("self-signed certificate is not supposed to be trusted by default")
+	}
+
+	// the same certificate from files
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	key, err := x509.MarshalPKCS8PrivateKey(cfg.Certificates[0].PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	must(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cfg.Certificates[0].Certificate[0]}), 0o600))
+	must(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+
+	loaded, err := NewListenerTLSConfig("", certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshakeWith(t, loaded, trusting(t, cfg, "localhost")); err != nil {
+		t.Errorf("handshake with certificate from files failed: %s", err)
+	}
+
+	for _, files := range [][2]string{{certFile, ""}, {"", keyFile}, {keyFile, certFile}} {
+		if _, err := NewListenerTLSConfig("", files[0], files[1]); err == nil {
+			t.Errorf("loading certificate %q with key %q is expected to fail", files[0], files[1])
+		}
+	}
+}
+
+func TestHandshakeTLSTimeout(t *testing.T) {
+	cfg, err := NewListenerTLSConfig("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	started := time.Now()
+	err = handshakeTLS(tls.Server(server, cfg), 100*time.Millisecond)
+	if err == nil || time.Since(started) > time.Second {
+		t.Errorf("silent client is expected to fail the handshake in time: %v", err)
+	}
+}
+
+func TestTLSListenerHandshakeBeforeSession(t *testing.T) {
+	inits := atomic.Int32{}
+	hubtest.Start(t, func(conn *websocket.Conn, msg *hubtest.Message) bool {
+		if msg.MessageType == string(MTPortForwardInit) {
+			inits.Add(1)
+		}
+		return hubtest.AckAll(conn, msg)
+	})
+
+	ctl := NewController(RemoteSpec{AgentId: "test", Namespace: "default", PodName: "pod/x", RemotePort: 1}, "127.0.0.1", 0, "token", NewTimeouts(time.Second, Timeouts{}))
+	ctl.DrainTimeout = time.Second
+	var err error
+	ctl.TLSConfig, err = NewListenerTLSConfig("127.0.0.1", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = ctl.Run(ctx, func(addr string) {
+		go func() {
+			defer cancel()
+
+			plain, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = plain.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+			_, _ = io.ReadAll(plain) // the server gives up on the handshake
+			_ = plain.Close()
+
+			conn, err := tls.Dial("tcp", addr, trusting(t, ctl.TLSConfig, "127.0.0.1"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = conn.Write([]byte("hello"))
+			time.Sleep(100 * time.Millisecond) // let the session start
+			_ = conn.Close()
+			time.Sleep(100 * time.Millisecond)
+		}()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the preflight check and the TLS client
+	if n := inits.Load(); n != 2 {
+		t.Errorf("client failing TLS handshake is not supposed to open a session, sessions: %d", n)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,71 @@
+package portforward
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/komodorio/komocli/pkg/portforward")
+
+const (
+	attrCluster     = attribute.Key("komodor.cluster")
+	attrNamespace   = attribute.Key("k8s.namespace.name")
+	attrResource    = attribute.Key("komodor.resource")
+	attrContainer   = attribute.Key("k8s.container.name")
+	attrSessionId   = attribute.Key("komodor.session_id")
+	attrTransport   = attribute.Key("komocli.transport")
+	attrPeer        = attribute.Key("komocli.peer")
+	attrBytesUp     = attribute.Key("komocli.bytes.upstream")
+	attrBytesDown   = attribute.Key("komocli.bytes.downstream")
+	attrMessageType = attribute.Key("komocli.message_type")
+)
+
+// sessionAttributes describe the target of the session, taken from its init message
+func sessionAttributes(agentId string, initMsg *SessionMessage) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attrCluster.String(agentId), attrMessageType.String(string(initMsg.MessageType))}
+	switch data := initMsg.Data.(type) {
+	case *WSPortForwardInitData:
+		attrs = append(attrs, attrNamespace.String(data.Namespace), attrResource.String(data.Resource))
+	case *WSPodExecInitData:
+		attrs = append(attrs, attrNamespace.String(data.Namespace), attrResource.String(data.PodName), attrContainer.String(data.ContainerName))
+	}
+	return attrs
+}
+
+// endSpan records the error, if any, and finishes the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startInitAckSpan measures the wait for session init to be acknowledged, it has to start before init is sent
+func (ws *WSConnectionWrapper) startInitAckSpan() {
+	ws.mxTrace.Lock()
+	defer ws.mxTrace.Unlock()
+	_, ws.initAckSpan = tracer.Start(ws.ctx, "session.init_ack")
+}
+
+func (ws *WSConnectionWrapper) endInitAckSpan(err error) {
+	ws.mxTrace.Lock()
+	defer ws.mxTrace.Unlock()
+	if ws.initAckSpan == nil {
+		return
+	}
+
+	if err == nil {
+		ws.initAckSpan.SetAttributes(attrSessionId.String(ws.SessionId))
+		trace.SpanFromContext(ws.ctx).SetAttributes(attrSessionId.String(ws.SessionId))
+	}
+	endSpan(ws.initAckSpan, err)
+	ws.initAckSpan = nil
+}
+
+// traceBytes adds transferred byte counts to the span of the session
+func (ws *WSConnectionWrapper) traceBytes() {
+	trace.SpanFromContext(ws.ctx).SetAttributes(attrBytesUp.Int64(ws.bytesUp.Load()), attrBytesDown.Int64(ws.bytesDown.Load()))
+}
@@ -0,0 +1,319 @@
+package portforward
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTP transport needs Komodor endpoint that is not part of the hub contract (see types.go) yet,
+// so it is never used unless requested with --transport http or --transport auto
+const (
+	TransportAuto      = "auto"      // WebSocket, falling back to HTTP when upgrade is blocked
+	TransportWebSocket = "websocket" // WebSocket only, the default
+	TransportHTTP      = "http"      // HTTP POST upstream and event stream downstream
+)
+
+// HTTPStreamHeader identifies the downstream event stream that POSTed messages belong to
+const HTTPStreamHeader = "X-Komocli-Stream"
+
+// Transport carries SessionMessage JSON frames between the client and Komodor backend
+type Transport interface {
+	WriteMessage(data []byte) error
+	ReadMessage() ([]byte, error)
+	Close() error
+}
+
+// HandshakeError is returned when Komodor backend or something on the way to it answers the handshake with non-success status
+type HandshakeError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("%s: status %d", e.Err, e.StatusCode)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// DialTransport connects to Komodor backend with the transport selected in options, WebSocket by default
+func DialTransport(ctx context.Context, agentId string, jwt string, timeouts Timeouts, opts *DialOptions) (Transport, error) {
+	if opts == nil {
+		opts = &DialOptions{}
+	}
+
+	if opts.Transport == TransportHTTP {
+		return DialHTTP(ctx, agentId, jwt, timeouts, opts)
+	}
+
+	conn, resp, err := DialWS(ctx, agentId, jwt, timeouts, opts)
+	if err == nil {
+		return &wsTransport{conn: conn}, nil
+	}
+
+	if resp != nil {
+		log.Errorf("handshake failed with status %d", resp.StatusCode)
+		err = &HandshakeError{StatusCode: resp.StatusCode, Err: err}
+	}
+
+	if opts.Transport != TransportAuto || !isUpgradeBlocked(err, resp) {
+		return nil, err
+	}
+
+	log.Warnf("WebSocket upgrade did not succeed, falling back to HTTP transport: %s", err)
+	metricTransportFallbacks.Inc()
+	transport, httpErr := DialHTTP(ctx, agentId, jwt, timeouts, opts)
+	if httpErr != nil {
+		return nil, errors.Join(err, fmt.Errorf("fallback to HTTP transport failed: %w", httpErr))
+	}
+	return transport, nil
+}
+
+func transportName(t Transport) string {
+	if _, ok := t.(*httpTransport); ok {
+		return TransportHTTP
+	}
+	return TransportWebSocket
+}
+
+// isUpgradeBlocked tells if something on the way to the server did not let the upgrade through
+func isUpgradeBlocked(err error, resp *http.Response) bool {
+	if !errors.Is(err, websocket.ErrBadHandshake) || resp == nil {
+		return false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return fromProxy(resp) // refused by the backend itself, fallback would fail the same way
+	default:
+		return true
+	}
+}
+
+// fromProxy tells if the response was produced or passed on by a proxy, which may refuse upgrades with 403
+func fromProxy(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return true
+	}
+
+	for _, name := range []string{"Via", "Proxy-Authenticate", "X-Squid-Error", "X-Proxy-Error"} {
+		if resp.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type wsTransport struct {
+	conn *websocket.Conn
+}
+
+func (t *wsTransport) WriteMessage(data []byte) error {
+	return t.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (t *wsTransport) ReadMessage() ([]byte, error) {
+	_, bts, err := t.conn.ReadMessage()
+	return bts, err
+}
+
+func (t *wsTransport) Close() error {
+	return t.conn.Close()
+}
+
+// httpTransport sends each message as HTTP POST and receives messages from server-sent event stream
+type httpTransport struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	client   *http.Client
+	endpoint string
+	header   http.Header
+	timeouts Timeouts
+
+	body   io.ReadCloser
+	reader *bufio.Reader
+
+	mx     sync.Mutex
+	closed bool
+}
+
+// httpClientEndpoint converts WS client endpoint into the one for HTTP transport
+func httpClientEndpoint(base string, agentId string, jwt string) (string, http.Header) {
+	wsURL, hdr := wsClientEndpoint(base, agentId, jwt)
+	endpoint := strings.Replace(wsURL, "ws", "http", 1) // ws:// and wss:// alike
+	endpoint = strings.Replace(endpoint, "/ws/client/", "/http/client/", 1)
+	hdr.Set(HTTPStreamHeader, uuid.NewString())
+	return endpoint, hdr
+}
+
+// DialHTTP opens downstream event stream to Komodor backend, for networks where WebSocket is not available
+func DialHTTP(ctx context.Context, agentId string, jwt string, timeouts Timeouts, opts *DialOptions) (Transport, error) {
+	endpoint, hdr := httpClientEndpoint(opts.baseURL(), agentId, jwt)
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := opts.ProxyFor(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := opts.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   timeouts.Handshake,
+			ResponseHeaderTimeout: max(timeouts.Handshake, timeouts.DataAck), // POSTs are limited by data ack timeout
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return opts.DialTCP(ctx, proxy, addr, timeouts.Dial)
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	t := &httpTransport{
+		ctx:      ctx,
+		cancel:   cancel,
+		client:   client,
+		endpoint: endpoint,
+		header:   hdr,
+		timeouts: timeouts,
+	}
+
+	if proxy != nil {
+		log.Infof("Connecting to HTTP backend at %s via proxy %s", endpoint, proxy.Redacted())
+	} else {
+		log.Infof("Connecting to HTTP backend at %s", endpoint)
+	}
+
+	err = t.openStream()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *httpTransport) newRequest(ctx context.Context, method string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = t.header.Clone()
+	return req, nil
+}
+
+func (t *httpTransport) openStream() error {
+	req, err := t.newRequest(t.ctx, http.MethodGet, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return &HandshakeError{StatusCode: resp.StatusCode, Err: errors.New("failed to open event stream")}
+	}
+
+	t.body = resp.Body
+	t.reader = bufio.NewReader(resp.Body)
+	return nil
+}
+
+func (t *httpTransport) WriteMessage(data []byte) error {
+	if t.isClosed() {
+		return net.ErrClosed
+	}
+
+	ctx, cancel := context.WithTimeout(t.ctx, t.timeouts.DataAck)
+	defer cancel()
+
+	req, err := t.newRequest(ctx, http.MethodPost, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to send message: status %s", resp.Status)
+	}
+	return nil
+}
+
+// ReadMessage returns data of the next server-sent event, comments and other fields are skipped
+func (t *httpTransport) ReadMessage() ([]byte, error) {
+	data := bytes.Buffer{}
+	for {
+		line, err := t.reader.ReadString('\n')
+		if err != nil {
+			if t.isClosed() {
+				return nil, net.ErrClosed
+			}
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF // server is not supposed to end the stream
+			}
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "" && data.Len() > 0:
+			return data.Bytes(), nil
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
+
+func (t *httpTransport) isClosed() bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.closed
+}
+
+func (t *httpTransport) Close() error {
+	t.mx.Lock()
+	if t.closed {
+		t.mx.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mx.Unlock()
+
+	t.cancel()
+	return t.body.Close()
+}
@@ -0,0 +1,175 @@
+package portforward
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newHTTPStandIn serves HTTP transport only, acknowledging every message it receives
+func newHTTPStandIn(t *testing.T) *httptest.Server {
+	mx := sync.Mutex{}
+	streams := map[string]chan []byte{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/ws/") {
+			w.WriteHeader(http.StatusBadRequest) // as if proxy stripped upgrade headers
+			return
+		}
+
+		id := r.Header.Get(HTTPStreamHeader)
+		switch r.Method {
+		case http.MethodGet:
+			ch := make(chan []byte, 10)
+			mx.Lock()
+			streams[id] = ch
+			mx.Unlock()
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			_, _ = fmt.Fprint(w, ": connected\n\n")
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case bts := <-ch:
+					_, _ = fmt.Fprintf(w, "data: %s\n\n", bts)
+					w.(http.Flusher).Flush()
+				case <-r.Context().Done():
+					return
+				}
+			}
+		case http.MethodPost:
+			mx.Lock()
+			ch, ok := streams[id]
+			mx.Unlock()
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			msg := SessionMessage{}
+			err := json.NewDecoder(r.Body).Decode(&msg)
+			if err != nil {
+				t.Errorf("failed to decode message: %s", err)
+				return
+			}
+
+			if msg.MessageType != MTTermination {
+				bts, _ := json.Marshal(&SessionMessage{MessageType: MTAck, Data: &WSAckData{AckedMessageID: msg.MessageId}})
+				ch <- bts
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+}
+
+func TestHTTPTransportFallback(t *testing.T) {
+	srv := newHTTPStandIn(t)
+	defer srv.Close()
+
+	t.Setenv("KOMOCLI_WS_URL", strings.Replace(srv.URL, "http", "ws", 1))
+	t.Setenv("KOMOCLI_DEV", "1")
+
+	cases := []struct {
+		transport  string
+		shouldFail bool
+	}{
+		{transport: TransportAuto, shouldFail: false},
+		{transport: TransportHTTP, shouldFail: false},
+		{transport: TransportWebSocket, shouldFail: true},
+		{transport: "", shouldFail: true}, // HTTP transport is opt-in
+	}
+
+	for _, c := range cases {
+		ctl := NewController(RemoteSpec{AgentId: "test", Namespace: "default", PodName: "pod/x", RemotePort: 1}, "", 0, "token", NewTimeouts(time.Second, Timeouts{}))
+		ctl.Dial = &DialOptions{Transport: c.transport}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := ctl.TestConnection(ctx)
+		cancel()
+
+		if (err != nil) != c.shouldFail {
+			t.Errorf("unexpected result with %s transport: %v", c.transport, err)
+		}
+	}
+}
+
+func TestHTTPTransportFallbackFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	t.Setenv("KOMOCLI_WS_URL", strings.Replace(srv.URL, "http", "ws", 1))
+
+	_, err := DialTransport(context.Background(), "test", "token", NewTimeouts(time.Second, Timeouts{}), &DialOptions{Transport: TransportAuto})
+	if err == nil {
+		t.Fatal("dial is expected to fail")
+	}
+
+	if !errors.Is(err, websocket.ErrBadHandshake) || !strings.Contains(err.Error(), "fallback to HTTP transport failed") {
+		t.Errorf("both WebSocket and HTTP errors are expected: %s", err)
+	}
+
+	hsErr := &HandshakeError{}
+	if !errors.As(err, &hsErr) || hsErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("handshake status is expected to be kept: %v", err)
+	}
+}
+
+func TestReadEventStream(t *testing.T) {
+	stream := ": comment\n\nevent: message\ndata: {\"a\":\ndata: 1}\n\ndata: x\r\n\r\n"
+	tr := &httpTransport{}
+	tr.reader = bufio.NewReader(strings.NewReader(stream))
+
+	for _, expected := range []string{"{\"a\":\n1}", "x"} {
+		bts, err := tr.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bts) != expected {
+			t.Errorf("unexpected message %q, expected %q", bts, expected)
+		}
+	}
+
+	_, err := tr.ReadMessage()
+	if err == nil {
+		t.Errorf("end of stream is expected to fail")
+	}
+}
+
+func TestIsUpgradeBlocked(t *testing.T) {
+	cases := []struct {
+		status  int
+		header  http.Header
+		blocked bool
+	}{
+		{status: http.StatusBadRequest, blocked: true},
+		{status: http.StatusProxyAuthRequired, blocked: true},
+		{status: http.StatusForbidden, blocked: false},
+		{status: http.StatusForbidden, header: http.Header{"Via": {"1.1 squid"}}, blocked: true},
+		{status: http.StatusUnauthorized, header: http.Header{"Proxy-Authenticate": {"Basic"}}, blocked: true},
+		{status: http.StatusUnauthorized, blocked: false},
+	}
+
+	for _, c := range cases {
+		resp := &http.Response{StatusCode: c.status, Header: c.header}
+		if resp.Header == nil {
+			resp.Header = http.Header{}
+		}
+
+		if isUpgradeBlocked(websocket.ErrBadHandshake, resp) != c.blocked {
+			t.Errorf("unexpected decision for %d %v, expected blocked=%v", c.status, c.header, c.blocked)
+		}
+	}
+}
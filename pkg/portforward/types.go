@@ -80,10 +80,12 @@ func (m *SessionMessage) UnmarshalJSON(b []byte) error {
 }
 
 type WSPodExecInitData struct {
-	Namespace     string `json:"namespace"`
-	PodName       string `json:"podName"`
-	ContainerName string `json:"containerName"`
-	Cmd           string `json:"cmd"`
+	Namespace     string   `json:"namespace"`
+	PodName       string   `json:"podName"`
+	ContainerName string   `json:"containerName"`
+	Cmd           string   `json:"cmd"`            // Argv shell-quoted and joined with spaces, for hubs that don't know the fields below
+	TTY           bool     `json:"tty"`            // allocate a terminal for the process, hubs that don't know it always do
+	Argv          []string `json:"argv,omitempty"` // takes precedence over Cmd, arguments may contain spaces
 }
 
 type WSPortForwardInitData struct {
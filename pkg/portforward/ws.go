@@ -5,29 +5,36 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
+	"github.com/komodorio/komocli/pkg/logging"
 	cmap "github.com/orcaman/concurrent-map/v2"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net"
-	"net/http"
-	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const DefaultWSAddress = "wss://app.komodor.com"
+// pendingAck tracks the message waiting to be acknowledged
+type pendingAck struct {
+	cancel context.CancelFunc
+	sent   time.Time
+}
 
 type WSConnectionWrapper struct {
 	ctx        context.Context
+	cancel     context.CancelCauseFunc
 	tcpConn    net.Conn
-	wsConn     *websocket.Conn
+	transport  Transport
 	agentId    string
 	jwt        string
 	isConnTest bool
+	probing    bool // opened via Open, acks do not finish connection test
 	SessionId  string
 	initMsg    *SessionMessage
 
@@ -35,40 +42,44 @@ type WSConnectionWrapper struct {
 	graceful           bool
 	mx                 sync.Mutex
 	mxWrites           sync.Mutex
-	closed             bool
+	closed             atomic.Bool
 	readBuf            bytes.Buffer
-	timeout            time.Duration
-	pendingAckMessages cmap.ConcurrentMap[string, context.CancelFunc]
+	timeouts           Timeouts
+	pendingAckMessages cmap.ConcurrentMap[string, pendingAck]
 	ackTimeoutErr      error
+	limits             SessionLimits
+	lastActivity       atomic.Int64
+	lastReceived       atomic.Int64
+	started            time.Time
+	pendingPings       cmap.ConcurrentMap[string, time.Time]
+	probeWaiters       cmap.ConcurrentMap[string, chan time.Duration]
+	Latency            LatencyStats
+	Dial               *DialOptions
+	Dump               *FrameDumper
+	dumpConn           int
+	Events             *EventSink
+	bytesUp            atomic.Int64
+	bytesDown          atomic.Int64
+	lastAckRTT         atomic.Int64 // of the latest acknowledged message, in nanoseconds
+	exitCode           atomic.Int64 // reported by remote side in termination message
+	mxTrace            sync.Mutex
+	initAckSpan        trace.Span   // until init is acknowledged
+	sessionIdForLogs   atomic.Value // SessionId for readers not synchronized via chReady
 }
 
 func (ws *WSConnectionWrapper) Run() error {
+	defer ws.cancel(nil)
 	defer func() {
 		if !ws.isConnTest {
-			log.Infof("Done working with connection: %v", ws.tcpConn.LocalAddr())
+			ws.logger().Infof("Done working with connection: %v", ws.tcpConn.LocalAddr())
 			_ = ws.tcpConn.Close()
 		}
 	}()
 
-	base := os.Getenv("KOMOCLI_WS_URL")
-	if base == "" {
-		base = DefaultWSAddress
-	}
-
-	hdr := http.Header{}
-	url := fmt.Sprintf("%s/ws/client/%s", base, ws.agentId)
-
-	if os.Getenv("KOMOCLI_DEV") == "" {
-		c := http.Cookie{Name: "JWT_TOKEN", Value: ws.jwt}
-		hdr.Set("Cookie", c.String())
-	} else {
-		url += "?authorization=" + ws.jwt
-	}
-
 	var err error
-	ws.wsConn, err = ws.connectWS(url, hdr)
+	ws.transport, err = ws.connectWS()
 	if err != nil {
-		log.Warnf("Failed to open WebSocket connection: %+v", err)
+		ws.logger().Warnf("Failed to connect to Komodor backend: %+v", err)
 		return err
 	}
 
@@ -83,10 +94,13 @@ func (ws *WSConnectionWrapper) Run() error {
 	go ws.writeLoop(readingDone)
 	go ws.readLoop(writingDone)
 	go ws.loopKeepAlive()
+	go ws.watchLimits()
+	go ws.loopPing()
+	go ws.watchPeer()
 
 	select { // wait either
 	case <-ws.ctx.Done():
-		err = ws.ctx.Err()
+		err = context.Cause(ws.ctx)
 	case e := <-writingDone:
 		err = e
 	case <-readingDone:
@@ -102,31 +116,43 @@ func (ws *WSConnectionWrapper) Run() error {
 		}
 	}
 
+	if ws.Latency.Count() > 0 {
+		ws.logger().Infof("Session %s latency: %s", ws.SessionId, &ws.Latency)
+	}
+
 	return err
 }
 
 func (ws *WSConnectionWrapper) init() error {
-	log.Infof("Initializing session...")
+	ws.logger().Infof("Initializing session...")
+
+	_, span := tracer.Start(ws.ctx, "session.init")
 
 	// write initial msg
 	ws.initMsg.MessageId = uuid.New().String()
 	ws.initMsg.Timestamp = time.Now()
 
-	return ws.sendWS(ws.initMsg, true)
+	ws.startInitAckSpan()
+	err := ws.sendWS(ws.initMsg, true)
+	if err != nil {
+		ws.endInitAckSpan(err)
+	}
+	endSpan(span, err)
+	return err
 }
 
 func (ws *WSConnectionWrapper) writeLoop(readingDone chan struct{}) {
 	// write loop
 	if ws.isConnTest {
-		log.Debugf("Not trying to send data due to validation loop")
+		ws.logger().Debugf("Not trying to send data due to validation loop")
 		return
 	}
 
-	log.Debugf("Starting tcp->ws transfer")
+	ws.logger().Debugf("Starting tcp->ws transfer")
 	n, err := io.Copy(ws, ws.tcpConn)
-	log.Infof("Done tcp->ws transfer: %d bytes", n)
+	ws.logger().Infof("Done tcp->ws transfer: %d bytes", n)
 	if err != nil && !isConnClosedErr(err) {
-		log.Warnf("Problems transfering tcp->ws: %s", err)
+		ws.logger().Warnf("Problems transfering tcp->ws: %s", err)
 	}
 	close(readingDone)
 }
@@ -140,9 +166,9 @@ func (ws *WSConnectionWrapper) readLoop(writingDone chan error) {
 		wr = ws.tcpConn
 	}
 	n, err := io.Copy(wr, ws)
-	log.Infof("Done ws->tcp transfer: %d bytes", n)
+	ws.logger().Infof("Done ws->tcp transfer: %d bytes", n)
 	if err != nil && !isConnClosedErr(err) {
-		log.Warnf("Problems in ws->tcp transfer: %s", err)
+		ws.logger().Warnf("Problems in ws->tcp transfer: %s", err)
 		writingDone <- err
 	}
 	close(writingDone)
@@ -153,12 +179,12 @@ func (ws *WSConnectionWrapper) loopKeepAlive() {
 		return // no keepalive for conn test
 	}
 
-	ticker := time.NewTicker(5 * time.Second) // TODO: parameterize it?
+	ticker := time.NewTicker(ws.timeouts.KeepAlive)
 	defer ticker.Stop()
 
 	for {
 		_, ok := <-ticker.C
-		if !ok || ws.closed { // if it is stopped
+		if !ok || ws.closed.Load() { // if it is stopped
 			break
 		}
 
@@ -170,16 +196,17 @@ func (ws *WSConnectionWrapper) loopKeepAlive() {
 
 		err := ws.sendWS(ws.newSessMessage(MTKeepAlive, &WSKeepaliveData{}), true)
 		if err != nil {
-			log.Errorf("Failed to send keep-alive message: %s", err)
+			metricKeepAliveFailures.Inc()
+			ws.logger().Errorf("Failed to send keep-alive message: %s", err)
 			err := ws.Stop()
 			if err != nil {
-				log.Warnf("Failed to stop session: %s", err)
+				ws.logger().Warnf("Failed to stop session: %s", err)
 			}
 			break
 		}
 	}
 
-	log.Debugf("KeepAlive loop done")
+	ws.logger().Debugf("KeepAlive loop done")
 }
 
 func (ws *WSConnectionWrapper) sendWS(msg *SessionMessage, needsAck bool) error {
@@ -188,20 +215,21 @@ func (ws *WSConnectionWrapper) sendWS(msg *SessionMessage, needsAck bool) error
 
 	txt, err := json.Marshal(msg)
 	if err != nil {
-		log.Errorf("Failed to serialize output message: %s", err)
+		ws.logger().Errorf("Failed to serialize output message: %s", err)
 		return err
 	}
 
-	log.Debugf("Sending WS message: %s", txt)
-	err = ws.wsConn.WriteMessage(websocket.TextMessage, txt)
+	ws.logger().Debugf("Sending WS message: %s", logging.Payload(txt))
+	ws.Dump.record(ws.dumpConn, FrameSent, txt)
+	err = ws.transport.WriteMessage(txt)
 	if err != nil {
-		log.Errorf("Failed to send output message over WS: %s", err)
+		ws.logger().Errorf("Failed to send output message over WS: %s", err)
 		return err
 	}
 
 	if needsAck {
-		ctx, cancel := context.WithTimeout(ws.ctx, ws.timeout)
-		ws.pendingAckMessages.Set(msg.MessageId, cancel)
+		ctx, cancel := context.WithTimeout(ws.ctx, ws.ackTimeout(msg))
+		ws.pendingAckMessages.Set(msg.MessageId, pendingAck{cancel: cancel, sent: time.Now()})
 		go ws.expectAck(ctx, msg)
 	}
 
@@ -210,34 +238,38 @@ func (ws *WSConnectionWrapper) sendWS(msg *SessionMessage, needsAck bool) error
 
 func (ws *WSConnectionWrapper) expectAck(ctx context.Context, msg *SessionMessage) {
 	<-ctx.Done() // wait for ctx to potentially expire
-	if cancel, found := ws.pendingAckMessages.Get(msg.MessageId); found {
-		cancel()
+	if pending, found := ws.pendingAckMessages.Get(msg.MessageId); found {
+		pending.cancel()
 		if ctx.Err() != nil {
-			log.Warnf("Did not receive ack within timeout for message %s: %s", msg.MessageId, ctx.Err())
+			metricAckTimeouts.Inc()
+			ws.logger().Warnf("Did not receive ack within timeout for message %s: %s", msg.MessageId, ctx.Err())
+			ws.Events.Emit(EventAckTimeout, ws.eventFields(EventFields{"message_id": msg.MessageId, "message_type": msg.MessageType}))
 			err := ws.Stop()
 			if err != nil {
-				log.Warnf("Failed to stop session: %s", err)
+				ws.logger().Warnf("Failed to stop session: %s", err)
 			}
 			ws.ackTimeoutErr = ctx.Err()
 		}
 	}
 }
 
-func (ws *WSConnectionWrapper) connectWS(url string, hdr http.Header) (*websocket.Conn, error) {
-	dialer := websocket.DefaultDialer
-	log.Infof("Connecting to WS backend at %s", url)
-	conn, resp, err := dialer.DialContext(ws.ctx, url, hdr)
-	if err != nil {
-		if resp != nil {
-			log.Errorf("handshake failed with status %d", resp.StatusCode)
-		}
-		return nil, err
+func (ws *WSConnectionWrapper) connectWS() (Transport, error) {
+	if ws.Dump != nil && !ws.isConnTest {
+		ws.dumpConn = ws.Dump.nextConn() // preflight check stays PreflightConn
+	}
+
+	ctx, span := tracer.Start(ws.ctx, "ws.dial")
+	transport, err := DialTransport(ctx, ws.agentId, ws.jwt, ws.timeouts, ws.Dial)
+	if err == nil {
+		span.SetAttributes(attrTransport.String(transportName(transport)))
 	}
-	return conn, nil
+	endSpan(span, err)
+	return transport, err
 }
 
 func (ws *WSConnectionWrapper) Write(b []byte) (n int, err error) {
 	<-ws.chReady // we need to wait for ack before writing anything
+	ws.touch()
 
 	// we received data via TCP and now want to translate it into WS message
 	msg := ws.newSessMessage(MTStdin, &WSStdinData{
@@ -248,16 +280,33 @@ func (ws *WSConnectionWrapper) Write(b []byte) (n int, err error) {
 	if err != nil {
 		return 0, err
 	}
+	metricBytes.WithLabelValues("upstream").Add(float64(len(b)))
+	ws.bytesUp.Add(int64(len(b)))
 
 	// loop bridged messages
 	return len(b), err
 }
 
+// Resize tells the remote side about new size of the local terminal, for exec sessions
+func (ws *WSConnectionWrapper) Resize(width, height uint16) error {
+	select {
+	case <-ws.chReady:
+	case <-ws.ctx.Done():
+		return context.Cause(ws.ctx)
+	}
+	return ws.sendWS(ws.newSessMessage(MTTerminalSize, &WSTerminalSizeData{Width: width, Height: height}), false)
+}
+
+// ExitCode returns the exit code of the remote process, once the session was terminated by remote side
+func (ws *WSConnectionWrapper) ExitCode() int {
+	return int(ws.exitCode.Load())
+}
+
 func (ws *WSConnectionWrapper) Read(b []byte) (int, error) {
 	// read from pushed msg into b
 	n, err := ws.readBuf.Read(b)
 	if err == io.EOF {
-		if !ws.closed {
+		if !ws.closed.Load() {
 			err = nil // let it just finish the iteration
 		}
 
@@ -271,22 +320,24 @@ func (ws *WSConnectionWrapper) Read(b []byte) (int, error) {
 	}
 
 	if n > 0 {
-		log.Debugf("Bridged ws->tcp: %d bytes", n)
+		ws.logger().Debugf("Bridged ws->tcp: %d bytes", n)
 	}
 
 	return n, err
 }
 
 func (ws *WSConnectionWrapper) readWS() error {
-	_, bts, err := ws.wsConn.ReadMessage()
+	bts, err := ws.transport.ReadMessage()
 	if err != nil {
 		if !isConnClosedErr(err) {
-			log.Warnf("Failed to read message from WS: %s", err)
+			ws.logger().Warnf("Failed to read message from WS: %s", err)
 		}
 		return err
 	}
 
-	log.Debugf("Read msg over WS: %s", bts)
+	ws.lastReceived.Store(time.Now().UnixNano())
+	ws.logger().Debugf("Read msg over WS: %s", logging.Payload(bts))
+	ws.Dump.record(ws.dumpConn, FrameReceived, bts)
 	var msg SessionMessage
 	err = json.Unmarshal(bts, &msg)
 	if err != nil {
@@ -295,6 +346,9 @@ func (ws *WSConnectionWrapper) readWS() error {
 
 	if ws.isInitAck(&msg) {
 		ws.SessionId = msg.SessionId
+		ws.sessionIdForLogs.Store(msg.SessionId)
+		ws.Events.Emit(EventSessionInitialized, ws.eventFields(nil))
+		ws.endInitAckSpan(nil)
 		close(ws.chReady) // ready to write data into WS
 	}
 
@@ -311,30 +365,40 @@ func (ws *WSConnectionWrapper) handleMsg(msg *SessionMessage) error {
 		ws.receiveOutput(msg)
 	case MTAck:
 		return ws.handleMsgAck(msg)
+	case MTPing:
+		ws.respondPing(msg)
 	case MTError:
+		countRemoteError(msg.Data.(*WSErrorData).ErrorMessage)
+		ws.Events.Emit(EventRemoteError, ws.eventFields(EventFields{"message": msg.Data.(*WSErrorData).ErrorMessage}))
 		return fmt.Errorf("received error from remote: %s", msg.Data.(*WSErrorData).ErrorMessage)
 	case MTTermination:
-		log.Infof("Got termination message, gotta shutdown")
+		ws.logger().Infof("Got termination message, gotta shutdown")
+		if data, ok := msg.Data.(*WSSessionTerminationData); ok {
+			ws.exitCode.Store(int64(data.ProcessExitCode))
+		}
 		ws.graceful = true
 		return io.EOF
 	default:
-		log.Warnf("Unhandled WS message: %s", msg)
+		ws.logger().Warnf("Unhandled WS message of type %s: %s", msg.MessageType, msg.MessageId)
 	}
 	return nil
 }
 
 func (ws *WSConnectionWrapper) handleMsgAck(msg *SessionMessage) error {
 	var err error
-	if ws.isConnTest {
+	if ws.isConnTest && !ws.probing {
 		err = io.EOF // enough for connection test
 	}
 
 	acked := msg.Data.(*WSAckData).AckedMessageID
 
-	if _, ok := ws.pendingAckMessages.Get(acked); ok {
+	if pending, ok := ws.pendingAckMessages.Get(acked); ok {
 		ws.pendingAckMessages.Remove(acked)
-	} else {
-		log.Warnf("Received ack for unexpected message ID: %s", acked)
+		rtt := time.Since(pending.sent)
+		metricAckLatency.Observe(rtt.Seconds())
+		ws.lastAckRTT.Store(int64(rtt))
+	} else if !ws.handlePingAck(acked) {
+		ws.logger().Warnf("Received ack for unexpected message ID: %s", acked)
 	}
 	return err
 }
@@ -342,16 +406,19 @@ func (ws *WSConnectionWrapper) handleMsgAck(msg *SessionMessage) error {
 func (ws *WSConnectionWrapper) receiveOutput(msg *SessionMessage) {
 	payload, err := base64.StdEncoding.DecodeString(msg.Data.(*WSStdoutData).Out)
 	if err != nil {
-		log.Debugf("Failed to decode Base64: %s", err)
+		ws.logger().Debugf("Failed to decode Base64: %s", err)
 		err := ws.sendWS(ws.newSessMessage(MTError, &WSErrorData{
 			OriginalMessageID: msg.MessageId,
 			ErrorMessage:      fmt.Sprintf("Failed to decode Base64: %s", err),
 		}), false)
 		if err != nil {
-			log.Debugf("Failed to send WS err: %s", err)
+			ws.logger().Debugf("Failed to send WS err: %s", err)
 		}
 	} else {
+		ws.touch()
 		ws.readBuf.Write(payload)
+		metricBytes.WithLabelValues("downstream").Add(float64(len(payload)))
+		ws.bytesDown.Add(int64(len(payload)))
 	}
 }
 
@@ -359,31 +426,94 @@ func (ws *WSConnectionWrapper) Stop() error {
 	ws.mx.Lock()
 	defer ws.mx.Unlock()
 
-	if ws.closed {
-		log.Debugf("Already stopped")
+	if ws.closed.Load() {
+		ws.logger().Debugf("Already stopped")
+		return nil
+	}
+	ws.closed.Store(true)
+
+	_, span := tracer.Start(ws.ctx, "session.stop")
+	defer span.End()
+	ws.endInitAckSpan(errors.New("session stopped before init was acknowledged"))
+	ws.traceBytes()
+
+	if ws.transport == nil { // WS connection was never established
+		if !ws.isConnTest {
+			return ws.tcpConn.Close()
+		}
 		return nil
 	}
-	ws.closed = true
 
 	err := ws.sendWS(ws.newSessMessage(MTTermination, &WSSessionTerminationData{
 		ProcessExitCode: 0,
-		ExitMessage:     "Stopping",
+		ExitMessage:     ws.exitMessage(),
 	}), false)
 	if err != nil {
-		log.Debugf("Failed to send WS termination: %s", err)
+		ws.logger().Debugf("Failed to send WS termination: %s", err)
 		return err
 	}
 
 	if !ws.isConnTest {
-		log.Infof("Closing forwarded connection: %v", ws.tcpConn)
+		ws.logger().Infof("Closing forwarded connection: %s", ws.peer())
 		err = ws.tcpConn.Close()
 		if err != nil {
-			log.Debugf("Failed to close connection: %s", err)
+			ws.logger().Debugf("Failed to close connection: %s", err)
 			return err
 		}
 	}
 
-	return ws.wsConn.Close()
+	return ws.transport.Close()
+}
+
+// exitMessage explains the reason for session termination to the remote side
+func (ws *WSConnectionWrapper) exitMessage() string {
+	cause := context.Cause(ws.ctx)
+	if cause == nil || errors.Is(cause, context.Canceled) {
+		return "Stopping"
+	}
+	return cause.Error()
+}
+
+func (ws *WSConnectionWrapper) peer() string {
+	if ws.isConnTest {
+		return "connection test"
+	}
+	return ws.tcpConn.RemoteAddr().String()
+}
+
+// eventFields adds the session to event-specific fields
+func (ws *WSConnectionWrapper) eventFields(fields EventFields) EventFields {
+	res := EventFields{"cluster": ws.agentId, "peer": ws.peer()}
+	if id, ok := ws.sessionIdForLogs.Load().(string); ok {
+		res["session_id"] = id
+	}
+
+	for k, v := range fields {
+		res[k] = v
+	}
+	return res
+}
+
+// logger adds the session and its target to log lines
+func (ws *WSConnectionWrapper) logger() *log.Entry {
+	fields := log.Fields{"cluster": ws.agentId}
+	switch data := ws.initMsg.Data.(type) {
+	case *WSPortForwardInitData:
+		fields["namespace"] = data.Namespace
+		fields["resource"] = data.Resource
+	case *WSPodExecInitData:
+		fields["namespace"] = data.Namespace
+		fields["resource"] = data.PodName
+	}
+
+	if id, ok := ws.sessionIdForLogs.Load().(string); ok && id != "" {
+		fields["session_id"] = id
+	}
+
+	if !ws.isConnTest {
+		fields["peer"] = ws.tcpConn.RemoteAddr().String()
+	}
+	return log.WithFields(fields)
 }
 
 func (ws *WSConnectionWrapper) newSessMessage(t MessageType, payload interface{}) *SessionMessage {
@@ -396,9 +526,11 @@ func (ws *WSConnectionWrapper) newSessMessage(t MessageType, payload interface{}
 	}
 }
 
-func NewWSConnectionWrapper(ctx context.Context, conn net.Conn, agentId string, jwt string, isConnTest bool, initMsg SessionMessage, timeout time.Duration) *WSConnectionWrapper {
-	return &WSConnectionWrapper{
+func NewWSConnectionWrapper(ctx context.Context, conn net.Conn, agentId string, jwt string, isConnTest bool, initMsg SessionMessage, timeouts Timeouts) *WSConnectionWrapper {
+	ctx, cancel := context.WithCancelCause(ctx)
+	ws := &WSConnectionWrapper{
 		ctx:        ctx,
+		cancel:     cancel,
 		tcpConn:    conn,
 		isConnTest: isConnTest,
 		initMsg:    &initMsg, // this is intentional to accept dereferenced value, to create a copy of it
@@ -408,9 +540,15 @@ func NewWSConnectionWrapper(ctx context.Context, conn net.Conn, agentId string,
 
 		chReady: make(chan struct{}),
 
-		timeout:            timeout,
-		pendingAckMessages: cmap.New[context.CancelFunc](),
+		timeouts:           timeouts,
+		pendingAckMessages: cmap.New[pendingAck](),
+		pendingPings:       cmap.New[time.Time](),
+		probeWaiters:       cmap.New[chan time.Duration](),
+		started:            time.Now(),
 	}
+	ws.touch()
+	ws.lastReceived.Store(ws.started.UnixNano())
+	return ws
 }
 
 func isConnClosedErr(err error) bool {
@@ -0,0 +1,202 @@
+package recordings
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Event types of asciicast v2, https://docs.asciinema.org/manual/asciicast/v2/
+const (
+	EventOutput = "o"
+	EventResize = "r"
+)
+
+// Header is the first line of asciicast v2 file
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is a line of the cast after the header, serialized as [time, type, data] array
+type Event struct {
+	Time float64 // seconds since the start
+	Type string
+	Data string
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{e.Time, e.Type, e.Data})
+}
+
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var fields []json.RawMessage
+	err := json.Unmarshal(b, &fields)
+	if err != nil {
+		return err
+	}
+
+	if len(fields) != 3 {
+		return fmt.Errorf("expected event of 3 elements, got %d", len(fields))
+	}
+
+	for i, dst := range []any{&e.Time, &e.Type, &e.Data} {
+		err = json.Unmarshal(fields[i], dst)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cast writes the session output and resizes as asciicast v2, safe for concurrent use
+type Cast struct {
+	mx      sync.Mutex
+	file    io.WriteCloser
+	w       *bufio.Writer
+	started time.Time
+	pending []byte // incomplete UTF-8 sequence at the end of the last output
+	err     error  // the first write error, reported by Close
+}
+
+// Create starts the cast file, it is readable only by the owner as it may contain secrets shown in the terminal
+func Create(file string, hdr Header) (*Cast, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewCast(f, hdr)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func NewCast(file io.WriteCloser, hdr Header) (*Cast, error) {
+	c := &Cast{file: file, w: bufio.NewWriter(file), started: time.Now()}
+	hdr.Version = 2
+	if hdr.Timestamp == 0 {
+		hdr.Timestamp = c.started.Unix()
+	}
+
+	c.writeLine(hdr)
+	return c, c.err
+}
+
+// Write records the output of the session, it never fails so that the session goes on, see Close
+func (c *Cast) Write(b []byte) (int, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	data := append(c.pending, b...)
+	complete := completeUTF8(data)
+	c.pending = append([]byte(nil), data[complete:]...)
+	if complete > 0 {
+		c.writeLine(Event{Time: c.elapsed(), Type: EventOutput, Data: string(data[:complete])})
+	}
+	return len(b), nil
+}
+
+func (c *Cast) Resize(width, height uint16) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.writeLine(Event{Time: c.elapsed(), Type: EventResize, Data: fmt.Sprintf("%dx%d", width, height)})
+}
+
+// Close finishes the file, returning the first error of writing it
+func (c *Cast) Close() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if len(c.pending) > 0 {
+		c.writeLine(Event{Time: c.elapsed(), Type: EventOutput, Data: string(c.pending)})
+		c.pending = nil
+	}
+
+	err := c.w.Flush()
+	if c.err == nil {
+		c.err = err
+	}
+
+	err = c.file.Close()
+	if c.err == nil {
+		c.err = err
+	}
+	return c.err
+}
+
+func (c *Cast) elapsed() float64 {
+	return math.Round(time.Since(c.started).Seconds()*1e6) / 1e6
+}
+
+func (c *Cast) writeLine(v any) {
+	if c.err != nil {
+		return
+	}
+
+	line, err := json.Marshal(v)
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	_, err = c.w.Write(append(line, '\n'))
+	if err != nil {
+		c.err = err
+	}
+}
+
+// completeUTF8 returns the length of b without incomplete UTF-8 sequence at its end, which would get garbled in JSON
+func completeUTF8(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return len(b)
+			}
+			return i
+		}
+	}
+	return len(b)
+}
+
+// Reader reads the cast file, event by event
+type Reader struct {
+	Header Header
+	dec    *json.Decoder
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	res := &Reader{dec: json.NewDecoder(r)}
+	err := res.dec.Decode(&res.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cast header: %w", err)
+	}
+
+	if res.Header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d, only version 2 is supported", res.Header.Version)
+	}
+	return res, nil
+}
+
+// Next returns the next event, or io.EOF at the end of the file
+func (r *Reader) Next() (Event, error) {
+	ev := Event{}
+	err := r.dec.Decode(&ev)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return ev, fmt.Errorf("malformed cast event: %w", err)
+	}
+	return ev, err
+}
@@ -0,0 +1,145 @@
+package recordings
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func TestCastRoundTrip(t *testing.T) {
+	out := bytes.Buffer{}
+	c, err := NewCast(nopCloser{&out}, Header{Width: 100, Height: 30, Title: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snowman := []byte("☃") // split across writes, has to stay in one event
+	_, _ = c.Write([]byte("hello "))
+	_, _ = c.Write(snowman[:1])
+	_, _ = c.Write(snowman[1:])
+	c.Resize(120, 40)
+	_, _ = c.Write([]byte{0xe2, 0x98}) // cut off at the end of the session
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Header.Version != 2 || r.Header.Width != 100 || r.Header.Timestamp == 0 || r.Header.Title != "test" {
+		t.Errorf("unexpected header: %+v", r.Header)
+	}
+
+	var events []Event
+	for {
+		ev, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+
+	if len(events) != 4 || events[1].Data != "☃" || events[2].Type != EventResize || events[2].Data != "120x40" || events[3].Type != EventOutput {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	cast := `{"version": 2, "width": 80, "height": 24}
+[0.5, "o", "hello "]
+[1.0, "r", "100x30"]
+[60.0, "o", "world"]
+`
+	r, err := NewReader(strings.NewReader(cast))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.Buffer{}
+	started := time.Now()
+	err = Play(context.Background(), r, &out, 10, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "hello world" {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+
+	if elapsed := time.Since(started); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected about 200ms of playback with the idle limit, took %s", elapsed)
+	}
+}
+
+func TestAlwaysRecord(t *testing.T) {
+	t.Setenv(EnvRecordClusters, "prod-*, eu-prod")
+	for cluster, expected := range map[string]bool{"prod-us": true, "eu-prod": true, "staging": false} {
+		if always, err := AlwaysRecord(cluster); err != nil || always != expected {
+			t.Errorf("%s: expected %t, got %t (%v)", cluster, expected, always, err)
+		}
+	}
+
+	t.Setenv(EnvRecordClusters, "prod-[")
+	if _, err := AlwaysRecord("prod-us"); err == nil {
+		t.Errorf("malformed pattern is supposed to fail")
+	}
+}
+
+func TestList(t *testing.T) {
+	t.Setenv(EnvDir, t.TempDir())
+	file, err := NewFile(time.Date(2026, 10, 19, 10, 15, 0, 0, time.UTC), "prod", "default", "web-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Base(file) != "20261019-101500.000-prod-default-web-1.cast" {
+		t.Errorf("unexpected file name: %s", file)
+	}
+
+	c, err := Create(file, Header{Width: 80, Height: 24, Title: "prod/default/web-1: sh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = c.Write([]byte("$ "))
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(file); err == nil && info.Mode().Perm() != 0o600 && runtime.GOOS != "windows" {
+		t.Errorf("recording is supposed to be private, got %v", info.Mode())
+	}
+
+	out := bytes.Buffer{}
+	err = list(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "20261019-101500.000-prod-default-web-1 ") || !strings.Contains(out.String(), "prod/default/web-1: sh") {
+		t.Errorf("unexpected list:\n%s", out.String())
+	}
+
+	if found, err := findFile("20261019-101500.000-prod-default-web-1"); err != nil || found != file {
+		t.Errorf("expected the name to be found in the directory of recordings, got %s (%v)", found, err)
+	}
+}
@@ -0,0 +1,252 @@
+package recordings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/portforward"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+const flagSpeed = "speed"
+const flagIdleLimit = "idle-limit"
+
+var (
+	recordingsLong = templates.LongDesc(`
+		List and play exec sessions recorded in asciicast v2 format.
+
+		Sessions are recorded with 'komocli exec --record FILE', or always for clusters matching the patterns
+		in KOMOCLI_RECORD_CLUSTERS environment variable, separated by commas. The latter go into
+		the directory of recordings, which can be changed with KOMOCLI_RECORDINGS_DIR.
+
+		Cast files can also be played with asciinema or uploaded to asciinema.org player.`)
+
+	recordingsExample = templates.Examples(`
+		# List recordings in the directory of recordings
+		komocli recordings list
+
+		# Play the recording twice as fast, skipping pauses longer than 2 seconds
+		komocli recordings play 20261019-101500.000-prod-default-web-1.cast --speed 2 --idle-limit 2s`)
+)
+
+type PlayParams struct {
+	File      string
+	Speed     float64
+	IdleLimit time.Duration // pauses are shortened to it, 0 keeps them as recorded
+}
+
+func (p *PlayParams) AcceptArgs(cmd *cobra.Command, args []string) (err error) {
+	p.File, err = findFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	flags := cmd.Flags()
+	p.Speed, err = flags.GetFloat64(flagSpeed)
+	if err != nil {
+		return err
+	}
+
+	p.IdleLimit, err = flags.GetDuration(flagIdleLimit)
+	if err != nil {
+		return err
+	}
+
+	if p.Speed <= 0 || p.IdleLimit < 0 {
+		return errors.New("speed has to be positive and idle limit can't be negative")
+	}
+	return nil
+}
+
+// findFile accepts the path, or the name of the file in the directory of recordings
+func findFile(arg string) (string, error) {
+	_, err := os.Stat(arg)
+	if err == nil || filepath.Base(arg) != arg {
+		return arg, err
+	}
+
+	dir, dirErr := Dir()
+	if dirErr != nil {
+		return "", err
+	}
+
+	for _, name := range []string{arg, arg + fileExt} {
+		if _, statErr := os.Stat(filepath.Join(dir, name)); statErr == nil {
+			return filepath.Join(dir, name), nil
+		}
+	}
+	return "", err
+}
+
+func (p *PlayParams) Run(ctx context.Context, out io.Writer) error {
+	f, err := os.Open(p.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return err
+	}
+	return Play(ctx, r, out, p.Speed, p.IdleLimit)
+}
+
+// Play writes the output events with their original timing, adjusted by speed and idle limit
+func Play(ctx context.Context, r *Reader, out io.Writer, speed float64, idleLimit time.Duration) error {
+	last := 0.0
+	for {
+		ev, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		delay := time.Duration((ev.Time - last) * float64(time.Second))
+		last = ev.Time
+		if idleLimit > 0 {
+			delay = min(delay, idleLimit)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(float64(delay) / speed)):
+		}
+
+		if ev.Type != EventOutput {
+			log.Debugf("Skipping %q event: %s", ev.Type, ev.Data) // can't resize local terminal
+			continue
+		}
+
+		_, err = io.WriteString(out, ev.Data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// recording is a line of the list
+type recording struct {
+	Name     string
+	Started  time.Time
+	Duration time.Duration
+	Size     int64
+	Title    string
+}
+
+func readRecording(file string) (rec recording, err error) {
+	rec.Name = filepath.Base(file)
+	f, err := os.Open(file)
+	if err != nil {
+		return rec, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return rec, err
+	}
+	rec.Size = info.Size()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return rec, err
+	}
+	rec.Started, rec.Title = time.Unix(r.Header.Timestamp, 0), r.Header.Title
+
+	for {
+		ev, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return rec, nil
+		}
+		if err != nil {
+			return rec, err // the session may still be running
+		}
+		rec.Duration = time.Duration(ev.Time * float64(time.Second))
+	}
+}
+
+func list(out io.Writer) error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileExt))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files) // names start with the time
+
+	if len(files) == 0 {
+		_, err = fmt.Fprintf(out, "No recordings in %s\n", dir)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "NAME\tSTARTED\tDURATION\tSIZE\tTITLE")
+	for _, file := range files {
+		rec, err := readRecording(file)
+		if err != nil {
+			log.Warnf("Failed to read %s: %s", file, err)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", strings.TrimSuffix(rec.Name, fileExt), rec.Started.Format(time.DateTime),
+			rec.Duration.Round(time.Second), portforward.FormatBytes(rec.Size), rec.Title)
+	}
+	return tw.Flush()
+}
+
+func NewCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:     "recordings",
+		Short:   "List and play recorded exec sessions",
+		Long:    recordingsLong,
+		Example: recordingsExample,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List recordings in the directory of recordings",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			return list(c.OutOrStdout())
+		},
+	})
+
+	play := &cobra.Command{
+		Use:   "play FILE",
+		Short: "Play the recording in the terminal",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := PlayParams{}
+			err := opts.AcceptArgs(c, args)
+			if err != nil {
+				return err
+			}
+
+			return opts.Run(c.Context(), c.OutOrStdout())
+		},
+	}
+	setupPlayFlags(play)
+	cmd.AddCommand(play)
+
+	return cmd
+}
+
+func setupPlayFlags(cmd *cobra.Command) {
+	cmd.Flags().Float64(flagSpeed, 1, "Playback speed multiplier")
+	cmd.Flags().Duration(flagIdleLimit, 0, "Shorten pauses to this duration, 0 keeps them as recorded")
+}
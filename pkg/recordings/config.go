@@ -0,0 +1,74 @@
+package recordings
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EnvRecordClusters lists cluster name patterns, like prod-*, separated by commas. Exec sessions into matching
+// clusters are always recorded into Dir
+const EnvRecordClusters = "KOMOCLI_RECORD_CLUSTERS"
+
+// EnvDir overrides the directory of recordings
+const EnvDir = "KOMOCLI_RECORDINGS_DIR"
+
+const fileExt = ".cast"
+
+// Dir is where sessions get recorded automatically, and where recordings are listed from
+func Dir() (string, error) {
+	if dir := os.Getenv(EnvDir); dir != "" {
+		return dir, nil
+	}
+
+	cfg, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the directory of recordings, set %s: %w", EnvDir, err)
+	}
+	return filepath.Join(cfg, "komocli", "recordings"), nil
+}
+
+// AlwaysRecord tells if sessions into the cluster have to be recorded, malformed patterns are errors
+// rather than silently recording nothing
+func AlwaysRecord(cluster string) (bool, error) {
+	for _, pattern := range strings.Split(os.Getenv(EnvRecordClusters), ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		matched, err := path.Match(pattern, cluster)
+		if err != nil {
+			return false, fmt.Errorf("malformed pattern %q in %s: %w", pattern, EnvRecordClusters, err)
+		}
+
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// NewFile names a recording in Dir after the start time and the parts, like cluster and pod names
+func NewFile(started time.Time, parts ...string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return "", err
+	}
+
+	name := started.Format("20060102-150405.000")
+	for _, part := range parts {
+		if part != "" {
+			name += "-" + strings.NewReplacer("/", "_", `\`, "_", ":", "_").Replace(part)
+		}
+	}
+	return filepath.Join(dir, name+fileExt), nil
+}
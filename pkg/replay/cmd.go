@@ -0,0 +1,98 @@
+package replay
+
+import (
+	"errors"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/portforward"
+	"github.com/spf13/cobra"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+const flagConn = "conn"
+const flagTimeout = "timeout"
+
+var (
+	replayLong = templates.LongDesc(`
+		Replay recorded session against local fake Komodor endpoint.
+
+		Takes the file written by 'komocli port-forward --dump-frames', and plays the messages
+		Komodor has sent in the recorded order, waiting for the client to send its messages in between.
+		Any difference in client behavior is reported as divergence, so protocol problems can be reproduced
+		without access to the cluster.`)
+
+	replayExample = templates.Examples(`
+		# Replay the first connection of the recording
+		komocli replay frames.jsonl
+
+		# Replay the third connection, waiting up to 10s for each client message
+		komocli replay frames.jsonl --conn 3 --timeout 10s`)
+)
+
+type CmdParams struct {
+	File    string
+	Conn    int
+	Timeout time.Duration
+}
+
+func (p *CmdParams) AcceptArgs(cmd *cobra.Command, args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("exactly one argument required for command")
+	}
+	p.File = args[0]
+
+	flags := cmd.Flags()
+	p.Conn, err = flags.GetInt(flagConn)
+	if err != nil {
+		return err
+	}
+
+	p.Timeout, err = flags.GetDuration(flagTimeout)
+	if err != nil {
+		return err
+	}
+
+	if p.Conn < 0 || p.Timeout <= 0 {
+		return errors.New("connection number can't be negative and timeout has to be positive")
+	}
+	return nil
+}
+
+func (p *CmdParams) Run(cmd *cobra.Command) error {
+	frames, err := portforward.ReadFrameDump(p.File)
+	if err != nil {
+		return err
+	}
+
+	r, err := NewReplayer(frames, p.Conn, p.Timeout, cmd.OutOrStdout())
+	if err != nil {
+		return err
+	}
+	return r.Run(cmd.Context())
+}
+
+func NewCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:     "replay FILE",
+		Short:   "Replay recorded session against local fake endpoint",
+		Long:    replayLong,
+		Example: replayExample,
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := CmdParams{}
+			err := opts.AcceptArgs(c, args)
+			if err != nil {
+				return err
+			}
+
+			return opts.Run(c)
+		},
+	}
+
+	setupFlags(cmd)
+	return cmd
+}
+
+func setupFlags(cmd *cobra.Command) {
+	cmd.Flags().Int(flagConn, 1, "Number of recorded connection to replay, starting from 1, or 0 for the preflight check")
+	cmd.Flags().Duration(flagTimeout, 5*time.Second, "Time to wait for each message from the client")
+}
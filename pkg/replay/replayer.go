@@ -0,0 +1,307 @@
+package replay
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/komodorio/komocli/pkg/portforward"
+	log "github.com/sirupsen/logrus"
+)
+
+// Replayer plays the ws-hub side of recorded session against WSConnectionWrapper.
+// The order of messages is reproduced, not their timing. Message IDs generated by the client
+// are different on each run, so references to them in acks and errors are rewritten.
+// Keep-alives depend on timing, so they are acknowledged as they come instead of being replayed.
+type Replayer struct {
+	frames  []portforward.DumpedFrame
+	timeout time.Duration
+	out     io.Writer
+
+	ids         map[string]string // recorded client message ID -> live one
+	skipped     map[string]bool   // recorded client messages that are not replayed
+	live        chan *portforward.SessionMessage
+	app         net.Conn // local application side of forwarded connection
+	appClosed   sync.Once
+	mxWrites    sync.Mutex
+	divergences atomic.Int32
+	played      atomic.Bool   // the script is played against the first connection only
+	done        chan struct{} // closed when the script finishes
+}
+
+func NewReplayer(frames []portforward.DumpedFrame, conn int, timeout time.Duration, out io.Writer) (*Replayer, error) {
+	r := &Replayer{
+		timeout: timeout,
+		out:     out,
+		ids:     map[string]string{},
+		skipped: map[string]bool{},
+		live:    make(chan *portforward.SessionMessage, 100),
+		done:    make(chan struct{}),
+	}
+
+	for _, f := range frames {
+		if f.Conn == conn {
+			r.frames = append(r.frames, f)
+		}
+	}
+
+	if len(r.frames) == 0 {
+		return nil, fmt.Errorf("no frames of connection %d in the dump", conn)
+	}
+	return r, nil
+}
+
+// initMessage finds the session initialization sent by the client, to start the live session the same way
+func (r *Replayer) initMessage() (*portforward.SessionMessage, error) {
+	for _, f := range r.frames {
+		if f.Direction != portforward.FrameSent {
+			continue
+		}
+
+		msg, err := decode(f.Frame)
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.MessageType == portforward.MTPortForwardInit || msg.MessageType == portforward.MTPodExecInit {
+			return msg, nil
+		}
+	}
+	return nil, errors.New("no session initialization message in the dump")
+}
+
+func (r *Replayer) Run(ctx context.Context) error {
+	initMsg, err := r.initMessage()
+	if err != nil {
+		return err
+	}
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(r.handle), ReadHeaderTimeout: r.timeout}
+	go func() { _ = srv.Serve(listen) }()
+	defer srv.Close()
+
+	var fwd net.Conn
+	r.app, fwd = net.Pipe()
+	received := make(chan int64)
+	go func() {
+		n, _ := io.Copy(io.Discard, r.app)
+		received <- n
+	}()
+
+	timeouts := portforward.NewTimeouts(r.timeout, portforward.Timeouts{})
+	ws := portforward.NewWSConnectionWrapper(ctx, fwd, "replay", "replay", false, *initMsg, timeouts)
+	ws.Dial = &portforward.DialOptions{BaseURL: "ws://" + listen.Addr().String(), Transport: portforward.TransportWebSocket}
+	runErr := ws.Run()
+	r.closeApp()
+
+	select { // the script has to finish reporting
+	case <-r.done:
+	case <-time.After(r.timeout):
+	}
+
+	_, _ = fmt.Fprintf(r.out, "\nReplayed %d frames, %d divergences, %d bytes delivered to the local side\n", len(r.frames), r.divergences.Load(), <-received)
+	if runErr != nil {
+		_, _ = fmt.Fprintf(r.out, "Session ended with error: %s\n", runErr)
+	}
+	return nil
+}
+
+func (r *Replayer) handle(w http.ResponseWriter, req *http.Request) {
+	if !r.played.CompareAndSwap(false, true) {
+		log.Warnf("Refusing extra connection from %s, the recording is replayed only once", req.RemoteAddr)
+		http.Error(w, "recording is already replayed", http.StatusConflict)
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Warnf("Failed to upgrade replay connection: %s", err)
+		r.played.Store(false)
+		return
+	}
+	defer conn.Close()
+	defer close(r.done)
+
+	go r.readLive(conn)
+	r.play(conn)
+
+	// let the client finish on its own, if it is going to
+	timeout := time.After(r.timeout)
+	for {
+		select {
+		case _, ok := <-r.live:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// readLive passes messages from the client to the script, acknowledging keep-alives right away
+func (r *Replayer) readLive(conn *websocket.Conn) {
+	defer close(r.live)
+	for {
+		_, bts, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		msg, err := decode(bts)
+		if err != nil {
+			log.Warnf("Failed to decode message from the client: %s", err)
+			continue
+		}
+
+		if msg.MessageType == portforward.MTKeepAlive || msg.MessageType == portforward.MTPing {
+			_ = r.write(conn, &portforward.SessionMessage{
+				MessageType: portforward.MTAck,
+				SessionId:   msg.SessionId,
+				Data:        &portforward.WSAckData{AckedMessageID: msg.MessageId},
+				Timestamp:   time.Now(),
+			})
+			continue
+		}
+		r.live <- msg
+	}
+}
+
+func (r *Replayer) play(conn *websocket.Conn) {
+	for i, f := range r.frames {
+		msg, err := decode(f.Frame)
+		if err != nil {
+			r.report(i, f, "malformed frame: %s", err)
+			continue
+		}
+
+		var ok bool
+		if f.Direction == portforward.FrameSent {
+			ok = r.expect(i, f, msg)
+		} else {
+			ok = r.send(i, f, msg, conn)
+		}
+
+		if !ok {
+			return
+		}
+	}
+	_, _ = fmt.Fprintf(r.out, "End of recording\n")
+}
+
+// expect waits for the client to send the same kind of message as recorded, feeding local data to it if needed
+func (r *Replayer) expect(i int, f portforward.DumpedFrame, recorded *portforward.SessionMessage) bool {
+	switch recorded.MessageType {
+	case portforward.MTKeepAlive, portforward.MTPing:
+		r.skipped[recorded.MessageId] = true
+		return true
+	case portforward.MTStdin:
+		data, err := base64.StdEncoding.DecodeString(recorded.Data.(*portforward.WSStdinData).Input)
+		if err != nil {
+			r.report(i, f, "malformed stdin data: %s", err)
+			return true
+		}
+		_, err = r.app.Write(data)
+		if err != nil {
+			r.report(i, f, "failed to feed local data: %s", err)
+			return false
+		}
+	case portforward.MTTermination:
+		r.closeApp() // the client terminates when local connection is closed
+	}
+
+	select {
+	case msg, ok := <-r.live:
+		if !ok {
+			r.report(i, f, "client disconnected while %s was expected", recorded.MessageType)
+			return false
+		}
+
+		r.ids[recorded.MessageId] = msg.MessageId
+		if msg.MessageType != recorded.MessageType {
+			r.divergences.Add(1)
+			r.report(i, f, "DIVERGENCE: expected %s, client sent %s", recorded.MessageType, msg.MessageType)
+		} else {
+			r.report(i, f, "%s", msg.MessageType)
+		}
+		return true
+	case <-time.After(r.timeout):
+		r.divergences.Add(1)
+		r.report(i, f, "DIVERGENCE: client did not send %s within %s", recorded.MessageType, r.timeout)
+		return false
+	}
+}
+
+// send passes recorded server message to the client, pointing its references to live message IDs
+func (r *Replayer) send(i int, f portforward.DumpedFrame, msg *portforward.SessionMessage, conn *websocket.Conn) bool {
+	switch data := msg.Data.(type) {
+	case *portforward.WSAckData:
+		if r.skipped[data.AckedMessageID] {
+			return true // acks for keep-alives are sent live
+		}
+		data.AckedMessageID = r.liveId(data.AckedMessageID)
+	case *portforward.WSErrorData:
+		data.OriginalMessageID = r.liveId(data.OriginalMessageID)
+	}
+
+	err := r.write(conn, msg)
+	if err != nil {
+		r.report(i, f, "failed to send %s: %s", msg.MessageType, err)
+		return false
+	}
+
+	r.report(i, f, "%s", msg.MessageType)
+	return true
+}
+
+func (r *Replayer) liveId(recorded string) string {
+	if id, ok := r.ids[recorded]; ok {
+		return id
+	}
+	return recorded
+}
+
+func (r *Replayer) write(conn *websocket.Conn, msg *portforward.SessionMessage) error {
+	r.mxWrites.Lock()
+	defer r.mxWrites.Unlock()
+	return conn.WriteJSON(msg)
+}
+
+func (r *Replayer) closeApp() {
+	r.appClosed.Do(func() {
+		_ = r.app.Close()
+	})
+}
+
+func (r *Replayer) report(i int, f portforward.DumpedFrame, format string, args ...interface{}) {
+	arrow := "<-"
+	if f.Direction == portforward.FrameSent {
+		arrow = "->"
+	}
+	elapsed := time.Duration(f.ElapsedNs).Truncate(time.Millisecond)
+	_, _ = fmt.Fprintf(r.out, "#%d +%s %s %s\n", i+1, elapsed, arrow, fmt.Sprintf(format, args...))
+}
+
+func decode(bts []byte) (*portforward.SessionMessage, error) {
+	msg := portforward.SessionMessage{}
+	err := json.Unmarshal(bts, &msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
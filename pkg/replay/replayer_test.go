@@ -0,0 +1,80 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/portforward"
+)
+
+func frame(t *testing.T, dir portforward.FrameDirection, msg portforward.SessionMessage) portforward.DumpedFrame {
+	bts, err := json.Marshal(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return portforward.DumpedFrame{Conn: 1, Direction: dir, Frame: bts}
+}
+
+func TestReplay(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString
+	frames := []portforward.DumpedFrame{
+		frame(t, portforward.FrameSent, portforward.SessionMessage{MessageId: "init", MessageType: portforward.MTPortForwardInit,
+			Data: &portforward.WSPortForwardInitData{Namespace: "default", Resource: "pod/x", Port: 1}}),
+		frame(t, portforward.FrameReceived, portforward.SessionMessage{MessageType: portforward.MTAck, SessionId: "s",
+			Data: &portforward.WSAckData{AckedMessageID: "init"}}),
+		frame(t, portforward.FrameSent, portforward.SessionMessage{MessageId: "ka", MessageType: portforward.MTKeepAlive,
+			Data: &portforward.WSKeepaliveData{}}),
+		frame(t, portforward.FrameReceived, portforward.SessionMessage{MessageType: portforward.MTAck,
+			Data: &portforward.WSAckData{AckedMessageID: "ka"}}),
+		frame(t, portforward.FrameSent, portforward.SessionMessage{MessageId: "in", MessageType: portforward.MTStdin,
+			Data: &portforward.WSStdinData{Input: b64([]byte("ping"))}}),
+		frame(t, portforward.FrameReceived, portforward.SessionMessage{MessageType: portforward.MTAck,
+			Data: &portforward.WSAckData{AckedMessageID: "in"}}),
+		frame(t, portforward.FrameReceived, portforward.SessionMessage{MessageType: portforward.MTStdout,
+			Data: &portforward.WSStdoutData{Out: b64([]byte("pong"))}}),
+		frame(t, portforward.FrameReceived, portforward.SessionMessage{MessageType: portforward.MTTermination,
+			Data: &portforward.WSSessionTerminationData{}}),
+	}
+
+	t.Setenv("KOMOCLI_WS_URL", "ws://127.0.0.1:1") // replay is not supposed to depend on it
+
+	out := bytes.Buffer{}
+	r, err := NewReplayer(frames, 1, 2*time.Second, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "0 divergences, 4 bytes delivered") {
+		t.Errorf("unexpected replay result:\n%s", out.String())
+	}
+
+	if os.Getenv("KOMOCLI_WS_URL") != "ws://127.0.0.1:1" {
+		t.Errorf("replay is not supposed to change the environment")
+	}
+
+	rec := httptest.NewRecorder()
+	r.handle(rec, httptest.NewRequest(http.MethodGet, "/ws/client/replay", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("extra connection is expected to be refused, got %d", rec.Code)
+	}
+
+	for _, conn := range []int{portforward.PreflightConn, 2} {
+		_, err = NewReplayer(frames, conn, time.Second, &out)
+		if err == nil {
+			t.Errorf("replaying missing connection %d is expected to fail", conn)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/internal/hubtest"
+	"github.com/komodorio/komocli/pkg/portforward"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is OTLP/HTTP collector stand-in, remembering names and attributes of received spans
+type collector struct {
+	mx    sync.Mutex
+	spans map[string]map[string]string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bts, err := io.ReadAll(r.Body)
+	if err != nil || r.URL.Path != "/v1/traces" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	req := coltracepb.ExportTraceServiceRequest{}
+	err = proto.Unmarshal(bts, &req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				attrs := map[string]string{}
+				for _, kv := range span.Attributes {
+					attrs[kv.Key] = kv.Value.String()
+				}
+				c.spans[span.Name] = attrs
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestTracing(t *testing.T) {
+	col := &collector{spans: map[string]map[string]string{}}
+	colSrv := httptest.NewServer(col)
+	defer colSrv.Close()
+
+	hubtest.Start(t, hubtest.AckAll)
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", colSrv.URL)
+
+	shutdown, err := Setup(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rSpec := portforward.RemoteSpec{AgentId: "my-cluster", Namespace: "default", PodName: "pod/x", RemotePort: 1}
+	ctl := portforward.NewController(rSpec, "", 0, "token", portforward.NewTimeouts(time.Second, portforward.Timeouts{}))
+	err = ctl.TestConnection(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col.mx.Lock()
+	defer col.mx.Unlock()
+	for _, name := range []string{"testConnection", "ws.dial", "session.init", "session.init_ack", "session.stop"} {
+		if _, ok := col.spans[name]; !ok {
+			t.Errorf("span %s was not exported, got: %v", name, col.spans)
+		}
+	}
+
+	attrs := col.spans["testConnection"]
+	if !strings.Contains(attrs["komodor.cluster"], "my-cluster") || !strings.Contains(attrs["komodor.session_id"], hubtest.SessionId) {
+		t.Errorf("unexpected attributes of testConnection span: %v", attrs)
+	}
+}
@@ -0,0 +1,168 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/portforward"
+	"github.com/pkg/browser"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+const flagToken = "token"
+const flagCluster = "cluster"
+const flagNamespace = "namespace"
+const flagAddress = "address"
+const flagPort = "port"
+const flagNoBrowser = "no-browser"
+const flagTimeout = "timeout"
+
+var (
+	uiLong = templates.LongDesc(`
+		Start local web page to manage port-forwards.
+
+		The page lists the clusters and namespaces given by flags, lets you start and stop forwards by clicking,
+		and streams the logs. It opens in browser automatically.
+
+		The page is protected with a random key that is part of its URL, only share it with people you trust
+		to use your token. Stopping komocli stops all the forwards.`)
+
+	uiExample = templates.Examples(`
+		# Open the page for two clusters
+		komocli ui --cluster my-cluster --cluster other-cluster --namespace default --token=...
+
+		# Serve on fixed port without opening the browser
+		komocli ui --cluster my-cluster --port 8800 --no-browser --token=...`)
+)
+
+type CmdParams struct {
+	Token     string
+	Clusters  []string
+	Namespace string
+	Address   string
+	Port      int
+	NoBrowser bool
+	Timeout   time.Duration
+	Dial      *portforward.DialOptions
+}
+
+func (p *CmdParams) AcceptArgs(cmd *cobra.Command) (err error) {
+	flags := cmd.Flags()
+	p.Token, err = flags.GetString(flagToken)
+	if err != nil {
+		return err
+	}
+
+	if p.Token == "" {
+		p.Token = os.Getenv("KOMOCLI_JWT")
+	}
+
+	if p.Token == "" {
+		return fmt.Errorf("--%s or KOMOCLI_JWT environment variable is required", flagToken)
+	}
+
+	p.Clusters, err = flags.GetStringSlice(flagCluster)
+	if err != nil {
+		return err
+	}
+
+	p.Namespace, err = flags.GetString(flagNamespace)
+	if err != nil {
+		return err
+	}
+
+	p.Address, err = flags.GetString(flagAddress)
+	if err != nil {
+		return err
+	}
+
+	p.Port, err = flags.GetInt(flagPort)
+	if err != nil {
+		return err
+	}
+
+	p.NoBrowser, err = flags.GetBool(flagNoBrowser)
+	if err != nil {
+		return err
+	}
+
+	p.Timeout, err = flags.GetDuration(flagTimeout)
+	if err != nil {
+		return err
+	}
+
+	if len(p.Clusters) == 0 {
+		return errors.New("at least one --cluster is required")
+	}
+
+	p.Dial, err = portforward.AcceptDialFlags(cmd)
+	return err
+}
+
+func (p *CmdParams) Run(ctx context.Context) error {
+	contexts := make([]Context, 0, len(p.Clusters))
+	for _, cluster := range p.Clusters {
+		contexts = append(contexts, Context{Cluster: cluster, Namespace: p.Namespace})
+	}
+
+	srv, err := NewServer(ctx, contexts, p.Token, portforward.NewTimeouts(p.Timeout, portforward.Timeouts{}))
+	if err != nil {
+		return err
+	}
+	srv.Dial = p.Dial
+
+	listen, err := net.Listen("tcp", net.JoinHostPort(p.Address, fmt.Sprint(p.Port)))
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://%s/?key=%s", listen.Addr(), srv.Key)
+	log.Infof("Serving komocli UI on %s", url)
+	if !p.NoBrowser {
+		err = browser.OpenURL(url)
+		if err != nil {
+			log.Warnf("Failed to open Web browser: %s", err)
+		}
+	}
+
+	return srv.Serve(listen)
+}
+
+func NewCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:     "ui",
+		Short:   "Start local web page to manage port-forwards",
+		Long:    uiLong,
+		Example: uiExample,
+		Args:    cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := CmdParams{}
+			err := opts.AcceptArgs(c)
+			if err != nil {
+				return err
+			}
+
+			return opts.Run(c.Context())
+		},
+	}
+
+	setupFlags(cmd)
+	return cmd
+}
+
+func setupFlags(cmd *cobra.Command) {
+	cmd.Flags().String(flagToken, "", "JWT Authentication token, used for all forwards started from the page")
+	cmd.Flags().StringSlice(flagCluster, nil, "Komodor cluster to offer in the page (repeatable)")
+	cmd.Flags().String(flagNamespace, "default", "Namespace to offer for each cluster")
+	cmd.Flags().String(flagAddress, "localhost", "Network address to serve the page on")
+	cmd.Flags().Int(flagPort, 0, "Port to serve the page on, random by default")
+	cmd.Flags().Bool(flagNoBrowser, false, "Do not open the page in browser")
+	cmd.Flags().Duration(flagTimeout, 5*time.Second, "Timeout for operations of the forwards")
+	portforward.SetupDialFlags(cmd)
+}
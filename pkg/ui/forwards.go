@@ -0,0 +1,139 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/komodorio/komocli/pkg/portforward"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateStopping = "stopping"
+	StateStopped  = "stopped"
+	StateFailed   = "failed"
+)
+
+var errStoppedFromUI = errors.New("stopped from UI")
+
+// Context is a cluster and namespace that forwards can be started in
+type Context struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+}
+
+// ForwardRequest describes a port-forward to start
+type ForwardRequest struct {
+	Cluster    string `json:"cluster"`
+	Namespace  string `json:"namespace"`
+	Resource   string `json:"resource"`
+	LocalPort  int    `json:"localPort"`
+	RemotePort int    `json:"remotePort"`
+}
+
+func (r *ForwardRequest) validate() error {
+	if r.Cluster == "" || r.Namespace == "" || r.Resource == "" {
+		return errors.New("cluster, namespace and resource are required")
+	}
+
+	if r.RemotePort <= 0 || r.RemotePort > 65535 || r.LocalPort < 0 || r.LocalPort > 65535 {
+		return errors.New("ports have to be within 1-65535, local port 0 picks a random one")
+	}
+	return nil
+}
+
+// ForwardView is the state of a forward, as shown in the page
+type ForwardView struct {
+	Id          string         `json:"id"`
+	Request     ForwardRequest `json:"request"`
+	State       string         `json:"state"`
+	URL         string         `json:"url,omitempty"`
+	Connections int            `json:"connections"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// forward is a port-forward running in background
+type forward struct {
+	id      string
+	request ForwardRequest
+	ctl     *portforward.Controller
+	cancel  context.CancelCauseFunc
+	done    chan struct{}
+
+	mx    sync.Mutex
+	state string
+	err   error
+}
+
+func (f *forward) setState(state string, err error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.state = state
+	f.err = err
+}
+
+func (f *forward) view() ForwardView {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	stats := f.ctl.Stats()
+	res := ForwardView{Id: f.id, Request: f.request, State: f.state, URL: stats.URL, Connections: len(stats.Conns)}
+	if f.err != nil {
+		res.Error = f.err.Error()
+	}
+	return res
+}
+
+func (f *forward) isFinished() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// startForward runs the forward in background until stopped or the context is done
+func (s *Server) startForward(ctx context.Context, req ForwardRequest) *forward {
+	rSpec := portforward.RemoteSpec{
+		AgentId:    req.Cluster,
+		Namespace:  req.Namespace,
+		PodName:    req.Resource,
+		RemotePort: req.RemotePort,
+	}
+
+	ctl := portforward.NewController(rSpec, "localhost", req.LocalPort, s.Token, s.Timeouts)
+	ctl.Dial = s.Dial
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	f := &forward{id: uuid.NewString(), request: req, ctl: ctl, cancel: cancel, done: make(chan struct{}), state: StateStarting}
+	go func() {
+		defer close(f.done)
+		defer cancel(nil)
+
+		err := ctl.Run(ctx, func(addr string) {
+			f.setState(StateRunning, nil)
+		})
+		if err != nil {
+			log.Warnf("Forward to %s/%s:%d has failed: %s", req.Namespace, req.Resource, req.RemotePort, err)
+			f.setState(StateFailed, fmt.Errorf("error while trying to forward port: %w", err))
+			return
+		}
+		f.setState(StateStopped, nil)
+	}()
+	return f
+}
+
+func (f *forward) stop() {
+	f.mx.Lock()
+	if f.state == StateStarting || f.state == StateRunning {
+		f.state = StateStopping
+	}
+	f.mx.Unlock()
+	f.cancel(errStoppedFromUI)
+}
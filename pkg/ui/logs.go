@@ -0,0 +1,64 @@
+package ui
+
+import (
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const logBacklog = 200 // lines shown to the page right after it connects
+
+// logHub is a logrus hook that passes formatted, redacted log lines to the pages streaming them
+type logHub struct {
+	mx      sync.Mutex
+	backlog []string
+	subs    map[chan string]struct{}
+}
+
+func newLogHub() *logHub {
+	return &logHub{subs: map[chan string]struct{}{}}
+}
+
+func (h *logHub) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *logHub) Fire(entry *log.Entry) error {
+	line, err := entry.String() // formatter of the logger takes care of redaction
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\n")
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.backlog = append(h.backlog, line)
+	if len(h.backlog) > logBacklog {
+		h.backlog = h.backlog[len(h.backlog)-logBacklog:]
+	}
+
+	for ch := range h.subs {
+		select {
+		case ch <- line:
+		default: // slow reader loses lines rather than blocking the logging
+		}
+	}
+	return nil
+}
+
+// subscribe returns the lines logged so far and the channel for the new ones
+func (h *logHub) subscribe() ([]string, chan string, func()) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	ch := make(chan string, logBacklog)
+	h.subs[ch] = struct{}{}
+	unsubscribe := func() {
+		h.mx.Lock()
+		defer h.mx.Unlock()
+		delete(h.subs, ch)
+	}
+	return append([]string(nil), h.backlog...), ch, unsubscribe
+}
@@ -0,0 +1,204 @@
+package ui
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/komodorio/komocli/pkg/portforward"
+	log "github.com/sirupsen/logrus"
+)
+
+// KeyHeader carries the access key of the page, so that other web sites can't drive the local API
+const KeyHeader = "X-Komocli-UI-Key"
+
+//go:embed index.html
+var indexHTML []byte
+
+// Server serves the local web dashboard and runs the forwards started from it
+type Server struct {
+	Contexts []Context
+	Token    string
+	Timeouts portforward.Timeouts
+	Dial     *portforward.DialOptions
+	Key      string
+
+	logs     *logHub
+	ctx      context.Context // forwards live within it
+	mx       sync.Mutex
+	forwards []*forward
+}
+
+func NewServer(ctx context.Context, contexts []Context, token string, timeouts portforward.Timeouts) (*Server, error) {
+	key := make([]byte, 16)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		Contexts: contexts,
+		Token:    token,
+		Timeouts: timeouts,
+		Key:      hex.EncodeToString(key),
+		logs:     newLogHub(),
+		ctx:      ctx,
+	}, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	router.GET("/", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", indexHTML)
+	})
+
+	api := router.Group("/api", s.authorize)
+	api.GET("/contexts", s.listContexts)
+	api.GET("/forwards", s.listForwards)
+	api.POST("/forwards", s.createForward)
+	api.DELETE("/forwards/:id", s.deleteForward)
+	api.GET("/logs", s.streamLogs)
+	return router
+}
+
+// Serve handles the page on the listener until the context is done, then stops all forwards
+func (s *Server) Serve(listen net.Listener) error {
+	log.AddHook(s.logs)
+
+	srv := &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-s.ctx.Done()
+		_ = srv.Close()
+	}()
+
+	err := srv.Serve(listen)
+	s.stopAll()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// authorize accepts the key from header, or from query for EventSource that can't set headers
+func (s *Server) authorize(c *gin.Context) {
+	key := c.GetHeader(KeyHeader)
+	if key == "" {
+		key = c.Query("key")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key), []byte(s.Key)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or wrong access key, open the URL printed by komocli"})
+		return
+	}
+	c.Next()
+}
+
+func (s *Server) listContexts(c *gin.Context) {
+	c.JSON(http.StatusOK, s.Contexts)
+}
+
+func (s *Server) listForwards(c *gin.Context) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	res := make([]ForwardView, 0, len(s.forwards))
+	for _, f := range s.forwards {
+		res = append(res, f.view())
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) createForward(c *gin.Context) {
+	req := ForwardRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = req.validate()
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !slices.Contains(s.Contexts, Context{Cluster: req.Cluster, Namespace: req.Namespace}) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("namespace %q in cluster %q is not among the contexts komocli ui was started with", req.Namespace, req.Cluster)})
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	f := s.startForward(s.ctx, req)
+	s.forwards = append(s.forwards, f)
+	log.Infof("Started forward from UI: %s/%s:%d in %s", req.Namespace, req.Resource, req.RemotePort, req.Cluster)
+	c.JSON(http.StatusCreated, f.view())
+}
+
+// deleteForward stops running forward, or removes the finished one from the list
+func (s *Server) deleteForward(c *gin.Context) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for i, f := range s.forwards {
+		if f.id != c.Param("id") {
+			continue
+		}
+
+		if f.isFinished() {
+			s.forwards = append(s.forwards[:i], s.forwards[i+1:]...)
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		f.stop()
+		c.JSON(http.StatusAccepted, f.view())
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "no such forward"})
+}
+
+func (s *Server) streamLogs(c *gin.Context) {
+	backlog, lines, unsubscribe := s.logs.subscribe()
+	defer unsubscribe()
+
+	for _, line := range backlog {
+		c.SSEvent("log", line)
+	}
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case line := <-lines:
+			c.SSEvent("log", line)
+			return true
+		}
+	})
+}
+
+func (s *Server) stopAll() {
+	s.mx.Lock()
+	forwards := append([]*forward(nil), s.forwards...)
+	s.mx.Unlock()
+
+	for _, f := range forwards {
+		f.stop()
+	}
+
+	for _, f := range forwards {
+		<-f.done
+	}
+}
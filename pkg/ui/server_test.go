@@ -0,0 +1,146 @@
+package ui
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/komodorio/komocli/pkg/internal/hubtest"
+	"github.com/komodorio/komocli/pkg/portforward"
+	log "github.com/sirupsen/logrus"
+)
+
+func call(t *testing.T, method string, url string, key string, body interface{}, result interface{}) int {
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(KeyHeader, key)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if result != nil {
+		_ = json.NewDecoder(resp.Body).Decode(result)
+	}
+	return resp.StatusCode
+}
+
+func TestServerForwards(t *testing.T) {
+	hubtest.Start(t, hubtest.AckAll)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, err := NewServer(ctx, []Context{{Cluster: "test", Namespace: "default"}}, "token", portforward.NewTimeouts(time.Second, portforward.Timeouts{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := httptest.NewServer(srv.Handler())
+	defer web.Close()
+	defer srv.stopAll()
+
+	if status := call(t, http.MethodGet, web.URL+"/api/contexts", "wrong", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("expected request with wrong key to be refused, got %d", status)
+	}
+
+	contexts := []Context{}
+	if call(t, http.MethodGet, web.URL+"/api/contexts", srv.Key, nil, &contexts); len(contexts) != 1 || contexts[0].Cluster != "test" {
+		t.Errorf("unexpected contexts: %v", contexts)
+	}
+
+	if status := call(t, http.MethodPost, web.URL+"/api/forwards", srv.Key, ForwardRequest{Cluster: "test", Namespace: "default", Resource: "pod/x"}, nil); status != http.StatusBadRequest {
+		t.Errorf("expected forward without remote port to be refused, got %d", status)
+	}
+
+	for _, req := range []ForwardRequest{
+		{Cluster: "prod", Namespace: "default", Resource: "pod/x", RemotePort: 80},
+		{Cluster: "test", Namespace: "kube-system", Resource: "pod/x", RemotePort: 80},
+	} {
+		if status := call(t, http.MethodPost, web.URL+"/api/forwards", srv.Key, req, nil); status != http.StatusForbidden {
+			t.Errorf("expected forward into %s/%s outside of contexts to be refused, got %d", req.Cluster, req.Namespace, status)
+		}
+	}
+
+	created := ForwardView{}
+	if status := call(t, http.MethodPost, web.URL+"/api/forwards", srv.Key, ForwardRequest{Cluster: "test", Namespace: "default", Resource: "pod/x", RemotePort: 80}, &created); status != http.StatusCreated {
+		t.Fatalf("failed to start forward: %d", status)
+	}
+
+	waitState := func(state string) ForwardView {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			forwards := []ForwardView{}
+			call(t, http.MethodGet, web.URL+"/api/forwards", srv.Key, nil, &forwards)
+			if len(forwards) == 1 && forwards[0].State == state {
+				return forwards[0]
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("forward did not become %s", state)
+		return ForwardView{}
+	}
+
+	if running := waitState(StateRunning); !strings.HasPrefix(running.URL, "http://") {
+		t.Errorf("expected local URL of running forward, got %q", running.URL)
+	}
+
+	if status := call(t, http.MethodDelete, web.URL+"/api/forwards/"+created.Id, srv.Key, nil, nil); status != http.StatusAccepted {
+		t.Errorf("failed to stop forward: %d", status)
+	}
+	waitState(StateStopped)
+
+	if status := call(t, http.MethodDelete, web.URL+"/api/forwards/"+created.Id, srv.Key, nil, nil); status != http.StatusNoContent {
+		t.Errorf("failed to remove stopped forward: %d", status)
+	}
+}
+
+func TestServerLogs(t *testing.T) {
+	srv, err := NewServer(context.Background(), nil, "token", portforward.Timeouts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := httptest.NewServer(srv.Handler())
+	defer web.Close()
+
+	logger := log.New()
+	logger.AddHook(srv.logs)
+	logger.SetOutput(&bytes.Buffer{})
+	logger.Infof("logged before the page connected")
+
+	resp, err := http.Get(web.URL + "/api/logs?key=" + srv.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	go logger.Infof("logged while streaming")
+
+	reader := bufio.NewReader(resp.Body)
+	received := ""
+	for !strings.Contains(received, "logged while streaming") {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended before expected lines, got: %s", received)
+		}
+		received += line
+	}
+
+	if !strings.Contains(received, "logged before the page connected") {
+		t.Errorf("expected backlog in the stream, got: %s", received)
+	}
+}